@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/hellojukay/sshw/sshwpkg"
+)
+
+const cpUsage = `usage:
+  sshw cp [-r] [-p] [-a [-c]] <source>... <target>   copy files between this machine and nodes, or between two nodes
+
+a remote operand is written <alias>:<path> (or <group/name>:<path>)`
+
+// runCp handles "sshw cp ..." and returns the process exit code.
+func runCp(args []string) int {
+	fs := flag.NewFlagSet("cp", flag.ContinueOnError)
+	recursive := fs.Bool("r", false, "copy directories recursively")
+	preserve := fs.Bool("p", false, "preserve modes and access and modification times")
+	resume := fs.Bool("a", false, "continue partially copied target files")
+	verify := fs.Bool("c", false, "with -a, compare checksums of the part already copied before continuing")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, cpUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	args = fs.Args()
+	err := sshw.Copy(args[:len(args)-1], args[len(args)-1], sshw.CopyOptions{Recursive: *recursive, Resume: *resume, Verify: *verify, Preserve: *preserve})
+	if err != nil {
+		log.Error(err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/hellojukay/sshw/sshwpkg"
+)
+
+const execUsage = `usage:
+  sshw exec [-p N] [-b|-json] <group|alias|tag> -- <command>   run a command on every node of a group or tag`
+
+// runExec handles "sshw exec ..." and returns the process exit code.
+func runExec(args []string) int {
+	fs := flag.NewFlagSet("exec", flag.ContinueOnError)
+	parallel := fs.Int("p", 10, "maximum number of nodes running the command at once")
+	gather := fs.Bool("b", false, "gather the output and fold nodes with identical output together")
+	asJSON := fs.Bool("json", false, "gather the output and print the results as JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, execUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	args = fs.Args()
+	if len(args) < 2 {
+		fs.Usage()
+		return 2
+	}
+
+	target, command := args[0], args[1:]
+	if command[0] == "--" {
+		command = command[1:]
+	}
+	if len(command) == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	nodes := sshw.FindTargets(sshw.GetConfig(), target)
+	if len(nodes) == 0 {
+		log.Error("no nodes found for:", target)
+		return 1
+	}
+
+	results := sshw.ExecFleet(nodes, strings.Join(command, " "), *parallel, *gather || *asJSON)
+	switch {
+	case *asJSON:
+		if err := sshw.PrintFleetJSON(os.Stdout, results); err != nil {
+			log.Error(err)
+			return 1
+		}
+	case *gather:
+		sshw.PrintFleetGathered(os.Stdout, results)
+	default:
+		fmt.Fprintln(os.Stderr)
+		sshw.PrintFleetSummary(os.Stderr, results)
+	}
+	return sshw.FleetExitCode(results)
+}
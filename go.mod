@@ -6,6 +6,7 @@ toolchain go1.24.1
 
 require (
 	github.com/atrox/homedir v1.0.0
+	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e
 	github.com/kevinburke/ssh_config v1.2.0
 	github.com/manifoldco/promptui v0.9.0
 	github.com/pkg/sftp v1.13.10
@@ -15,7 +16,6 @@ require (
 )
 
 require (
-	github.com/chzyer/readline v0.0.0-20180603132655-2972be24d48e // indirect
 	github.com/kr/fs v0.1.0 // indirect
 	github.com/mitchellh/colorstring v0.0.0-20190213212951-d06e56a500db // indirect
 	github.com/rivo/uniseg v0.4.7 // indirect
@@ -24,6 +24,10 @@ var (
 	H     = flag.Bool("help", false, "show help")
 	S     = flag.Bool("s", false, "use local ssh config '~/.ssh/config'")
 	F     = flag.String("f", "~/.sshw", "inventory config path")
+	X     = flag.Bool("X", false, "enable untrusted X11 forwarding")
+	Y     = flag.Bool("Y", false, "enable trusted X11 forwarding")
+	T     = flag.Bool("t", false, "force pseudo-terminal allocation for a remote command")
+	D     = flag.String("D", "", "run a SOCKS5 proxy on `[bind:]port` through the node given by alias, without a shell")
 
 	log = sshw.GetLogger()
 
@@ -34,19 +38,43 @@ var (
 	}
 )
 
-func findAlias(nodes []*sshw.Node, nodeAlias string) *sshw.Node {
-	for _, node := range nodes {
-		if node.Alias == nodeAlias {
-			return node
-		}
-		if len(node.Children) > 0 {
-			return findAlias(node.Children, nodeAlias)
-		}
+const usage = `sshw - ssh client wrapper for automatic login
+
+usage:
+  sshw [flags]                          choose a host from the menu
+  sshw [flags] <alias>                  login to the host
+  sshw [flags] <alias> -- <command>     run a command and exit with its status
+  sshw cp [-r] <source>... <target>     copy files from and to <alias>:<path>
+  sshw exec [-p N] <group> -- <command> run a command on every node of a group or tag
+  sshw cluster <group|alias|tag>...     type into several hosts at once
+  sshw tmux [-panes] [-sync] <group>... open every node in a tmux window or pane
+  sshw -D [bind:]port <alias>           run a SOCKS5 proxy through the host
+  sshw tunnel up|down|status [alias]    manage background tunnels
+
+flags:
+`
+
+// runsCommand reports whether sshw was asked to run commands instead of
+// opening a session: "sshw exec" or "sshw <alias> [--] <command>".
+func runsCommand() bool {
+	switch flag.Arg(0) {
+	case "exec":
+		return true
+	case "", "cp", "cluster", "tmux", "tunnel":
+		return false
 	}
-	return nil
+	command := flag.Args()[1:]
+	if len(command) > 0 && command[0] == "--" {
+		command = command[1:]
+	}
+	return *D == "" && len(command) > 0
 }
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprint(flag.CommandLine.Output(), usage)
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 	if !flag.Parsed() {
 		flag.Usage()
@@ -65,14 +93,28 @@ func main() {
 		return
 	}
 
+	// 执行远程命令时标准输出只留给命令本身，日志改写到标准错误
+	commandMode := runsCommand()
+	if commandMode {
+		sshw.SetLogOutput(os.Stderr)
+	}
+
 	// 设置信号处理，支持Ctrl+C退出
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go func() {
-		<-c
-		fmt.Println("\n程序已退出")
-		os.Exit(0)
-	}()
+	// tunnel run 自己处理信号，以便退出前清理控制文件
+	if flag.Arg(0) != "tunnel" || flag.Arg(1) != "run" {
+		c := make(chan os.Signal, 1)
+		signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			sig := <-c
+			if !commandMode {
+				fmt.Println("\n程序已退出")
+				os.Exit(0)
+			}
+			fmt.Fprintln(os.Stderr, "\n程序已退出")
+			// 按照 shell 惯例返回 128+信号值，便于脚本判断
+			os.Exit(128 + int(sig.(syscall.Signal)))
+		}()
+	}
 
 	if *S {
 		err := sshw.LoadSshConfig()
@@ -88,16 +130,53 @@ func main() {
 		}
 	}
 
+	if flag.Arg(0) == "cp" {
+		os.Exit(runCp(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "exec" {
+		os.Exit(runExec(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "cluster" {
+		os.Exit(runCluster(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "tmux" {
+		os.Exit(runTmux(flag.Args()[1:]))
+	}
+	if flag.Arg(0) == "tunnel" {
+		os.Exit(runTunnel(flag.Args()[1:]))
+	}
+
+	if *D != "" && flag.NArg() == 0 {
+		log.Error("-D requires a node alias")
+		os.Exit(1)
+	}
+
 	// login by alias
-	if len(os.Args) > 1 {
-		var nodeAlias = os.Args[1]
+	if flag.NArg() > 0 {
+		var nodeAlias = flag.Arg(0)
 		var nodes = sshw.GetConfig()
-		var node = findAlias(nodes, nodeAlias)
+		var node = sshw.FindNode(nodes, nodeAlias)
 		if node != nil {
+			applyFlags(node)
 			client := sshw.NewClient(node)
-			client.Login()
+			command := flag.Args()[1:]
+			if len(command) > 0 && command[0] == "--" {
+				command = command[1:]
+			}
+			switch {
+			case *D != "":
+				client.Socks(*D)
+			case len(command) > 0:
+				os.Exit(client.Exec(strings.Join(command, " "), *T))
+			default:
+				client.Login()
+			}
 			return
 		}
+		if *D != "" {
+			log.Error("alias not found:", nodeAlias)
+			os.Exit(1)
+		}
 	}
 
 	for {
@@ -112,6 +191,7 @@ func main() {
 			continue // 用户取消选择
 		}
 
+		applyFlags(node)
 		client := sshw.NewClient(node)
 
 		// 根据选择的连接类型执行相应操作
@@ -120,23 +200,55 @@ func main() {
 			client.Login()
 		case sshw.ConnTypeSFTP:
 			client.LoginSFTP()
+		case sshw.ConnTypeSOCKS:
+			client.Socks("")
+		case sshw.ConnTypeTunnel:
+			client.Tunnel()
+		case sshw.ConnTypeCluster:
+			if nodes := chooseMany("select hosts to broadcast to", node); nodes != nil {
+				sshw.Cluster(nodes)
+			}
+		case sshw.ConnTypeTmux:
+			if nodes := chooseMany("select hosts to open in tmux", node); nodes != nil {
+				if panes, sync, ok := chooseTmuxLayout(); ok {
+					if err := openTmux(nodes, panes, sync); err != nil {
+						log.Error(err)
+					}
+				}
+			}
 		}
 
 		sshw.FlushStdin()
 	}
 }
 
+// applyFlags overrides node settings with command line flags
+func applyFlags(node *sshw.Node) {
+	if *X {
+		node.ForwardX11 = true
+	}
+	if *Y {
+		node.ForwardX11Trusted = true
+	}
+}
+
 // chooseConnType displays connection type selection menu
 func chooseConnType(node *sshw.Node) *sshw.ConnType {
 	connTypes := []sshw.ConnType{
 		sshw.ConnTypeSSH,
 		sshw.ConnTypeSFTP,
+		sshw.ConnTypeSOCKS,
+		sshw.ConnTypeTunnel,
+		sshw.ConnTypeCluster,
+	}
+	if sshw.InTmux() {
+		connTypes = append(connTypes, sshw.ConnTypeTmux)
 	}
 
 	items := make([]string, len(connTypes))
 	for i, ct := range connTypes {
-		// 使用固定宽度的标签（左对齐，5个字符宽度）确保对齐
-		label := fmt.Sprintf("%-5s", ct.String())
+		// 使用固定宽度的标签（左对齐，7个字符宽度）确保对齐
+		label := fmt.Sprintf("%-7s", ct.String())
 		items[i] = fmt.Sprintf("%s - %s", label, ct.Description())
 	}
 
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hellojukay/sshw/sshwpkg"
+	"github.com/manifoldco/promptui"
+)
+
+const done = "-done-"
+
+// pick is an entry of the multi-select menu.
+type pick struct {
+	Node     *sshw.Node
+	Path     string
+	Selected bool
+}
+
+// leafPicks lists every host of the inventory with its group path.
+func leafPicks(prefix string, nodes []*sshw.Node, picks []*pick) []*pick {
+	for _, node := range nodes {
+		if node.Name == prev || node.Name == exit {
+			continue
+		}
+		path := node.Name
+		if prefix != "" {
+			path = prefix + " / " + node.Name
+		}
+		if len(node.Children) > 0 {
+			picks = leafPicks(path, node.Children, picks)
+			continue
+		}
+		picks = append(picks, &pick{Node: node, Path: path})
+	}
+	return picks
+}
+
+// chooseMany lets the user tick several hosts, starting with selected
+// ticked. It returns nil when the menu is cancelled.
+func chooseMany(label string, selected ...*sshw.Node) []*sshw.Node {
+	picks := append([]*pick{{Path: done}}, leafPicks("", sshw.GetConfig(), nil)...)
+	for _, p := range picks {
+		for _, n := range selected {
+			if p.Node == n {
+				p.Selected = true
+			}
+		}
+	}
+
+	prompt := promptui.Select{
+		Label:        label,
+		Items:        picks,
+		Size:         20,
+		HideSelected: true,
+		Templates: &promptui.SelectTemplates{
+			Label:    "✨ {{ . | green}}",
+			Active:   "➤ {{if .Node}}{{if .Selected}}[x]{{else}}[ ]{{end}} {{.Path | cyan}}{{if .Node.Alias}}({{.Node.Alias | yellow}}){{end}}{{else}}{{.Path | cyan}}{{end}}",
+			Inactive: "  {{if .Node}}{{if .Selected}}[x]{{else}}[ ]{{end}} {{.Path | faint}}{{if .Node.Alias}}({{.Node.Alias | faint}}){{end}}{{else}}{{.Path | faint}}{{end}}",
+		},
+	}
+
+	cursor, scroll := 0, 0
+	for {
+		index, _, err := prompt.RunCursorAt(cursor, scroll)
+		if err != nil {
+			return nil
+		}
+		cursor, scroll = index, prompt.ScrollPosition()
+
+		if picks[index].Node != nil {
+			picks[index].Selected = !picks[index].Selected
+			continue
+		}
+
+		var nodes []*sshw.Node
+		for _, p := range picks {
+			if p.Selected {
+				nodes = append(nodes, p.Node)
+			}
+		}
+		if len(nodes) > 0 {
+			return nodes
+		}
+		fmt.Println("no host selected")
+	}
+}
+
+// runCluster handles "sshw cluster ..." and returns the process exit code.
+func runCluster(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage:\n  sshw cluster <group|alias|tag>...   open a shell on every node and broadcast keystrokes")
+		return 2
+	}
+	var nodes []*sshw.Node
+	seen := make(map[*sshw.Node]bool)
+	for _, target := range args {
+		found := sshw.FindTargets(sshw.GetConfig(), target)
+		if len(found) == 0 {
+			log.Error("no nodes found for:", target)
+			return 1
+		}
+		for _, node := range found {
+			if !seen[node] {
+				seen[node] = true
+				applyFlags(node)
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	sshw.Cluster(nodes)
+	return 0
+}
@@ -0,0 +1,188 @@
+package sshw
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"sync"
+
+	"github.com/atrox/homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+var (
+	agentMu     sync.Mutex
+	agentClient agent.ExtendedAgent
+	agentConn   net.Conn
+)
+
+// localAgent returns a client for the ssh-agent listening on SSH_AUTH_SOCK.
+// It returns nil when no agent is available.
+func localAgent() agent.ExtendedAgent {
+	agentMu.Lock()
+	defer agentMu.Unlock()
+
+	if agentClient != nil {
+		return agentClient
+	}
+
+	sock := os.Getenv("SSH_AUTH_SOCK")
+	if sock == "" {
+		return nil
+	}
+	conn, err := net.Dial("unix", sock)
+	if err != nil {
+		l.Errorf("connect ssh-agent %s error: %v", sock, err)
+		return nil
+	}
+	agentConn = conn
+	agentClient = agent.NewClient(conn)
+	return agentClient
+}
+
+// resetAgent drops the cached agent connection so the next call to
+// localAgent dials SSH_AUTH_SOCK again, e.g. after the agent restarted.
+func resetAgent() {
+	agentMu.Lock()
+	defer agentMu.Unlock()
+
+	if agentConn != nil {
+		agentConn.Close()
+	}
+	agentConn = nil
+	agentClient = nil
+}
+
+// agentAuth offers the signers held by the local ssh-agent, restricted to
+// node.AgentIdentities when that list is set.
+func agentAuth(node *Node) ssh.AuthMethod {
+	if !node.useAgent() || localAgent() == nil {
+		return nil
+	}
+
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		a := localAgent()
+		if a == nil {
+			return nil, nil
+		}
+		signers, err := a.Signers()
+		if err != nil {
+			resetAgent()
+			l.Errorf("list ssh-agent keys error: %v", err)
+			return nil, nil
+		}
+		if len(node.AgentIdentities) == 0 {
+			return signers, nil
+		}
+
+		keys, err := a.List()
+		if err != nil {
+			resetAgent()
+			l.Errorf("list ssh-agent keys error: %v", err)
+			return nil, nil
+		}
+		var filtered []ssh.Signer
+		for _, s := range signers {
+			blob := s.PublicKey().Marshal()
+			for _, k := range keys {
+				if bytes.Equal(k.Blob, blob) && matchIdentity(node.AgentIdentities, k) {
+					filtered = append(filtered, s)
+					break
+				}
+			}
+		}
+		if len(filtered) == 0 {
+			l.Errorf("no ssh-agent key matches agent-identities of %s", node.Name)
+		}
+		return filtered, nil
+	})
+}
+
+// matchIdentity reports whether key is selected by one of identities. An
+// identity is a key comment, a SHA256/MD5 fingerprint or the path of a
+// public key file (a private key path is tried with ".pub" appended).
+func matchIdentity(identities []string, key *agent.Key) bool {
+	for _, id := range identities {
+		if id == key.Comment || id == ssh.FingerprintSHA256(key) || id == ssh.FingerprintLegacyMD5(key) {
+			return true
+		}
+		if pub, err := readPublicKey(id); err == nil && bytes.Equal(pub.Marshal(), key.Blob) {
+			return true
+		}
+	}
+	return false
+}
+
+// readPublicKey loads an authorized_keys formatted public key from path,
+// falling back to path.pub when path holds a private key.
+func readPublicKey(path string) (ssh.PublicKey, error) {
+	path, err := homedir.Expand(path)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range []string{path, path + ".pub"} {
+		b, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		if pub, _, _, _, err := ssh.ParseAuthorizedKey(b); err == nil {
+			return pub, nil
+		}
+	}
+	return nil, fmt.Errorf("no public key found at %s", path)
+}
+
+// forwardAgent serves the local ssh-agent to "auth-agent@openssh.com"
+// channels opened by the server and requests forwarding for session. With
+// forward-agent-confirm set, every signature has to be approved via ask.
+func forwardAgent(client *ssh.Client, session *ssh.Session, node *Node, ask func(string) bool) error {
+	a := localAgent()
+	if a == nil {
+		return fmt.Errorf("forward-agent is set for %s but no ssh-agent is available (SSH_AUTH_SOCK)", node.Name)
+	}
+
+	var keyring agent.Agent = a
+	if node.ForwardAgentConfirm {
+		keyring = &confirmAgent{ExtendedAgent: a, host: node.Host, ask: ask}
+	}
+	if err := agent.ForwardToAgent(client, keyring); err != nil {
+		return err
+	}
+	return agent.RequestAgentForwarding(session)
+}
+
+// confirmAgent asks before each signature made through a forwarded agent.
+type confirmAgent struct {
+	agent.ExtendedAgent
+	host string
+	ask  func(string) bool
+}
+
+func (a *confirmAgent) Sign(key ssh.PublicKey, data []byte) (*ssh.Signature, error) {
+	if !a.allow(key) {
+		return nil, fmt.Errorf("agent: signature refused by user")
+	}
+	return a.ExtendedAgent.Sign(key, data)
+}
+
+func (a *confirmAgent) SignWithFlags(key ssh.PublicKey, data []byte, flags agent.SignatureFlags) (*ssh.Signature, error) {
+	if !a.allow(key) {
+		return nil, fmt.Errorf("agent: signature refused by user")
+	}
+	return a.ExtendedAgent.SignWithFlags(key, data, flags)
+}
+
+func (a *confirmAgent) allow(key ssh.PublicKey) bool {
+	name := ssh.FingerprintSHA256(key)
+	if keys, err := a.List(); err == nil {
+		for _, k := range keys {
+			if bytes.Equal(k.Blob, key.Marshal()) && k.Comment != "" {
+				name = fmt.Sprintf("%s (%s)", k.Comment, name)
+				break
+			}
+		}
+	}
+	return a.ask(fmt.Sprintf("allow %s to sign with forwarded key %s?", a.host, name))
+}
@@ -0,0 +1,17 @@
+//go:build darwin || freebsd || netbsd
+
+package sshw
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// localAtime returns the access time of a local file.
+func localAtime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atimespec.Sec), int64(st.Atimespec.Nsec))
+	}
+	return info.ModTime()
+}
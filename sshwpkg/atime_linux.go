@@ -0,0 +1,17 @@
+//go:build linux
+
+package sshw
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// localAtime returns the access time of a local file.
+func localAtime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(int64(st.Atim.Sec), int64(st.Atim.Nsec))
+	}
+	return info.ModTime()
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd && !windows
+
+package sshw
+
+import (
+	"os"
+	"time"
+)
+
+// localAtime returns the modification time of a local file, since the
+// access time is not available on this platform.
+func localAtime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
@@ -0,0 +1,17 @@
+//go:build windows
+
+package sshw
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// localAtime returns the access time of a local file.
+func localAtime(info os.FileInfo) time.Time {
+	if attrs, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+		return time.Unix(0, attrs.LastAccessTime.Nanoseconds())
+	}
+	return info.ModTime()
+}
@@ -1,13 +1,12 @@
 package sshw
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"os/user"
 	"path/filepath"
-	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -40,11 +39,16 @@ var (
 type Client interface {
 	Login()
 	LoginSFTP()
+	Socks(listen string)
+	Tunnel()
+	Exec(command string, tty bool) int
 }
 
 type defaultClient struct {
 	clientConfig *ssh.ClientConfig
 	node         *Node
+	// pool, when set, provides shared jump hop connections
+	pool *hopPool
 }
 
 func genSSHConfig(node *Node) *defaultClient {
@@ -56,6 +60,10 @@ func genSSHConfig(node *Node) *defaultClient {
 
 	var authMethods []ssh.AuthMethod
 
+	if agentAuth := agentAuth(node); agentAuth != nil {
+		authMethods = append(authMethods, agentAuth)
+	}
+
 	var pemBytes []byte
 	if node.KeyPath == "" {
 		pemBytes, err = os.ReadFile(filepath.Join(u.HomeDir, ".ssh/id_rsa"))
@@ -70,7 +78,10 @@ func genSSHConfig(node *Node) *defaultClient {
 		pemBytes, err = os.ReadFile(node.KeyPath)
 	}
 	if err != nil {
-		l.Error(err)
+		// a missing default key is not worth reporting
+		if node.KeyPath != "" || !os.IsNotExist(err) {
+			l.Error(err)
+		}
 	} else {
 		var signer ssh.Signer
 		if node.Passphrase != "" {
@@ -92,18 +103,17 @@ func genSSHConfig(node *Node) *defaultClient {
 	}
 
 	authMethods = append(authMethods, ssh.KeyboardInteractive(func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		promptMu.Lock()
+		defer promptMu.Unlock()
 		answers := make([]string, 0, len(questions))
 		for i, q := range questions {
 			fmt.Print(q)
 			if echos[i] {
-				scan := bufio.NewScanner(os.Stdin)
-				if scan.Scan() {
-					answers = append(answers, scan.Text())
-				}
-				err := scan.Err()
+				answer, err := readLine()
 				if err != nil {
 					return nil, err
 				}
+				answers = append(answers, answer)
 			} else {
 				b, err := terminal.ReadPassword(int(syscall.Stdin))
 				if err != nil {
@@ -119,9 +129,12 @@ func genSSHConfig(node *Node) *defaultClient {
 	config := &ssh.ClientConfig{
 		User:            node.user(),
 		Auth:            authMethods,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(),
+		HostKeyCallback: hostKeyCallback(node),
 		Timeout:         time.Second * 10,
 	}
+	if node.hostKeyPolicy() != HostKeyPolicyInsecure {
+		config.HostKeyAlgorithms = knownHostKeyAlgorithms(node.addr())
+	}
 
 	config.SetDefaults()
 	config.Ciphers = append(config.Ciphers, DefaultCiphers...)
@@ -146,6 +159,10 @@ func (c *defaultClient) Login() {
 	host := c.node.Host
 	l.Infof("connect server ssh -p %d %s@%s version: %s\n", c.node.port(), c.node.user(), host, string(client.ServerVersion()))
 
+	// the session switches the terminal to raw mode
+	forwards := startForwards(client.Client, c.node, rawInfof)
+	defer forwards.Close()
+
 	session, err := client.NewSession()
 	if err != nil {
 		l.Error(err)
@@ -188,6 +205,22 @@ func (c *defaultClient) Login() {
 		l.Error(err)
 		return
 	}
+	input := &rawPrompt{WriteCloser: stdinPipe}
+
+	if c.node.ForwardAgent {
+		if err := forwardAgent(client.Client, session, c.node, input.ask); err != nil {
+			l.Error(err)
+		}
+	}
+
+	if c.node.ForwardX11 || c.node.ForwardX11Trusted {
+		x11, err := forwardX11(client.Client, session, c.node, rawInfof)
+		if err != nil {
+			l.Error(err)
+		} else {
+			defer x11.Close()
+		}
+	}
 
 	err = session.Shell()
 	if err != nil {
@@ -204,102 +237,273 @@ func (c *defaultClient) Login() {
 
 	// 启动可中断的输入转发（按操作系统实现）
 	done := make(chan struct{})
-	go forwardInput(fd, stdinPipe, done)
+	go forwardInput(fd, input, done)
+
+	go watchWindowSize(session, w, h)
+
+	// send keepalive
+	go keepAlive(client.Client)
 
+	session.Wait()
+
+	// 停止输入转发
+	close(done)
+}
+
+// watchWindowSize polls the local terminal size and reports changes to the
+// remote pty of session, starting from w x h.
+func watchWindowSize(session *ssh.Session, w, h int) {
 	// interval get terminal size
 	// fix resize issue
-	go func() {
-		var (
-			ow = w
-			oh = h
-		)
-		for {
-			cw, ch, err := terminal.GetSize(int(os.Stdout.Fd()))
+	var (
+		ow = w
+		oh = h
+	)
+	for {
+		cw, ch, err := terminal.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			break
+		}
+
+		if cw != ow || ch != oh {
+			err = session.WindowChange(ch, cw)
 			if err != nil {
 				break
 			}
+			ow = cw
+			oh = ch
+		}
+		time.Sleep(time.Second)
+	}
+}
 
-			if cw != ow || ch != oh {
-				err = session.WindowChange(ch, cw)
-				if err != nil {
-					break
-				}
-				ow = cw
-				oh = ch
-			}
-			time.Sleep(time.Second)
+// Socks connects to the node and serves its dynamic forwards as SOCKS5
+// proxies without opening a shell, until the connection drops. A non-empty
+// listen overrides the address of the first dynamic forward, or declares
+// one when the node has none.
+func (c *defaultClient) Socks(listen string) {
+	node := *c.node
+	node.Forwards = nil
+	node.RemoteForwards = nil
+	node.DynamicForwards = append([]*DynamicForward(nil), c.node.DynamicForwards...)
+	if len(node.DynamicForwards) == 0 {
+		node.DynamicForwards = []*DynamicForward{{Listen: "1080"}}
+	}
+	if listen != "" {
+		d := *node.DynamicForwards[0]
+		d.Listen = listen
+		node.DynamicForwards[0] = &d
+	}
+
+	client := c.createSSHClient()
+	if client == nil {
+		return
+	}
+	defer client.Close()
+
+	l.Infof("connect server socks -p %d %s@%s\n", c.node.port(), c.node.user(), c.node.Host)
+
+	forwards := startForwards(client.Client, &node, l.Infof)
+	defer forwards.Close()
+
+	go keepAlive(client.Client)
+	l.Info("SOCKS5 proxy running, press Ctrl+C to stop")
+	client.Wait()
+	l.Infof("connection to %s closed", c.node.Host)
+}
+
+// keepAlive pings the server every 10 seconds until the connection closes.
+func keepAlive(client *ssh.Client) {
+	for {
+		time.Sleep(time.Second * 10)
+		if _, _, err := client.SendRequest("keepalive@openssh.com", false, nil); err != nil {
+			return
 		}
-	}()
+	}
+}
 
-	// send keepalive
-	go func() {
-		for {
-			time.Sleep(time.Second * 10)
-			client.SendRequest("keepalive@openssh.com", false, nil)
+// probeAlive pings the server every 10 seconds like keepAlive but waits for
+// the replies. A server that stops answering for 30 seconds is considered
+// dead and the connection is closed, so that Wait returns and a tunnel can
+// reconnect.
+func probeAlive(client *ssh.Client) {
+	for {
+		time.Sleep(time.Second * 10)
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest("keepalive@openssh.com", true, nil)
+			reply <- err
+		}()
+		select {
+		case err := <-reply:
+			if err != nil {
+				return
+			}
+		case <-time.After(time.Second * 30):
+			l.Errorf("server %s not responding, closing connection", client.RemoteAddr())
+			client.Close()
+			return
 		}
-	}()
+	}
+}
 
-	session.Wait()
+// maxJumpDepth bounds how deeply jump hosts may nest their own jump lists.
+const maxJumpDepth = 16
 
-	// 停止输入转发
-	close(done)
+// sshClient is a connection to the target node together with the jump hop
+// clients it is tunnelled through. Close tears down the whole chain.
+type sshClient struct {
+	*ssh.Client
+	hops []*ssh.Client
 }
 
-func (c *defaultClient) createSSHClient() *ssh.Client {
-	host := c.node.Host
-	port := strconv.Itoa(c.node.port())
-	jNodes := c.node.Jump
+func (c *sshClient) Close() error {
+	var err error
+	if c.Client != nil {
+		err = c.Client.Close()
+	}
+	for i := len(c.hops) - 1; i >= 0; i-- {
+		c.hops[i].Close()
+	}
+	return err
+}
 
-	var client *ssh.Client
+// jumpChain returns the jump hosts of node in dial order. A jump host's own
+// jump list is dialed before it, so A -> B -> C -> target chains can be
+// described either flat or nested.
+func jumpChain(node *Node) ([]*Node, error) {
+	return appendJumps(nil, node, 0)
+}
 
-	if len(jNodes) > 0 {
-		jNode := jNodes[0]
-		jc := genSSHConfig(jNode)
-		proxyClient, err := ssh.Dial("tcp", net.JoinHostPort(jNode.Host, strconv.Itoa(jNode.port())), jc.clientConfig)
+func appendJumps(chain []*Node, node *Node, depth int) ([]*Node, error) {
+	if depth > maxJumpDepth {
+		return nil, fmt.Errorf("jump chain of %s is deeper than %d hops", node.Name, maxJumpDepth)
+	}
+	for _, j := range node.Jump {
+		var err error
+		chain, err = appendJumps(chain, j, depth+1)
 		if err != nil {
-			l.Error(err)
-			return nil
+			return nil, err
 		}
-		conn, err := proxyClient.Dial("tcp", net.JoinHostPort(host, port))
-		if err != nil {
-			l.Error(err)
-			proxyClient.Close()
-			return nil
+		chain = append(chain, j)
+	}
+	return chain, nil
+}
+
+func (c *defaultClient) createSSHClient() *sshClient {
+	client, err := c.dial()
+	if err != nil {
+		l.Error(err)
+		return nil
+	}
+	return client
+}
+
+// dial connects to the node through every hop of its jump chain. Each hop
+// authenticates with its own settings, and errors name the hop that failed.
+func (c *defaultClient) dial() (*sshClient, error) {
+	chain, err := jumpChain(c.node)
+	if err != nil {
+		return nil, err
+	}
+
+	client := &sshClient{}
+	var via *ssh.Client
+	var key string
+	for i, hop := range chain {
+		dialHop := func() (*ssh.Client, error) {
+			hc := genSSHConfig(hop)
+			if hc == nil {
+				return nil, errors.New("cannot build ssh config")
+			}
+			return hc.connect(via)
 		}
-		ncc, chans, reqs, err := ssh.NewClientConn(conn, net.JoinHostPort(host, port), c.clientConfig)
-		if err != nil {
-			l.Error(err)
-			proxyClient.Close()
-			return nil
+
+		var hopClient *ssh.Client
+		if c.pool != nil {
+			// shared hops belong to the pool and are not closed with the client
+			key += hop.user() + "@" + hop.addr() + " "
+			hopClient, err = c.pool.get(key, dialHop)
+		} else {
+			hopClient, err = dialHop()
+			if err == nil {
+				client.hops = append(client.hops, hopClient)
+			}
 		}
-		client = ssh.NewClient(ncc, chans, reqs)
-	} else {
-		client1, err := ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
-		client = client1
 		if err != nil {
-			msg := err.Error()
-			// use terminal password retry
-			if strings.Contains(msg, "no supported methods remain") && !strings.Contains(msg, "password") {
-				fmt.Printf("%s@%s's password:", c.clientConfig.User, host)
-				var b []byte
-				b, err = terminal.ReadPassword(int(syscall.Stdin))
-				if err == nil {
-					p := string(b)
-					if p != "" {
-						c.clientConfig.Auth = append(c.clientConfig.Auth, ssh.Password(p))
-					}
-					fmt.Println()
-					client, err = ssh.Dial("tcp", net.JoinHostPort(host, port), c.clientConfig)
+			client.Close()
+			return nil, fmt.Errorf("jump hop %d/%d %s@%s: %w", i+1, len(chain), hop.user(), hop.addr(), err)
+		}
+		via = hopClient
+	}
+
+	client.Client, err = c.connect(via)
+	if err != nil {
+		client.Close()
+		if len(chain) > 0 {
+			return nil, fmt.Errorf("target %s@%s via %d jump hop(s): %w", c.node.user(), c.node.addr(), len(chain), err)
+		}
+		return nil, err
+	}
+	return client, nil
+}
+
+// connect opens an ssh connection to c.node, directly when via is nil or
+// through the via client otherwise. When the server rejects every configured
+// auth method, the user is asked for a password and the dial is retried.
+func (c *defaultClient) connect(via *ssh.Client) (*ssh.Client, error) {
+	client, err := c.handshake(via)
+	if err != nil {
+		msg := err.Error()
+		// use terminal password retry
+		if strings.Contains(msg, "no supported methods remain") && !strings.Contains(msg, "password") {
+			promptMu.Lock()
+			fmt.Printf("%s@%s's password:", c.clientConfig.User, c.node.Host)
+			b, perr := terminal.ReadPassword(int(syscall.Stdin))
+			fmt.Println()
+			promptMu.Unlock()
+			if perr == nil {
+				p := string(b)
+				if p != "" {
+					c.clientConfig.Auth = append(c.clientConfig.Auth, ssh.Password(p))
 				}
+				client, err = c.handshake(via)
 			}
 		}
-		if err != nil {
-			l.Error(err)
-			return nil
-		}
 	}
+	return client, err
+}
 
-	return client
+func (c *defaultClient) handshake(via *ssh.Client) (*ssh.Client, error) {
+	addr := c.node.addr()
+
+	var conn net.Conn
+	var err error
+	switch {
+	case c.node.ProxyCommand != "" && via != nil:
+		return nil, fmt.Errorf("proxy-command of %s cannot be used behind a jump host", c.node.Name)
+	case c.node.ProxyCommand != "":
+		conn, err = proxyCommandConn(c.node)
+	case via != nil:
+		conn, err = via.Dial("tcp", addr)
+	default:
+		conn, err = net.DialTimeout("tcp", addr, c.clientConfig.Timeout)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if c.node.ProxyCommand != "" {
+		// nothing else bounds how long a proxy command takes to connect
+		conn.SetDeadline(time.Now().Add(c.clientConfig.Timeout))
+	}
+
+	ncc, chans, reqs, err := ssh.NewClientConn(conn, addr, c.clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(ncc, chans, reqs), nil
 }
 
 func (c *defaultClient) LoginSFTP() {
@@ -312,7 +516,10 @@ func (c *defaultClient) LoginSFTP() {
 	host := c.node.Host
 	l.Infof("connect server sftp -p %d %s@%s\n", c.node.port(), c.node.user(), host)
 
-	sftpClient, err := NewSFTPClient(client)
+	forwards := startForwards(client.Client, c.node, l.Infof)
+	defer forwards.Close()
+
+	sftpClient, err := NewSFTPClient(client.Client)
 	if err != nil {
 		l.Error(err)
 		return
@@ -320,6 +527,7 @@ func (c *defaultClient) LoginSFTP() {
 	defer sftpClient.Close()
 
 	shell := NewSFTPShell(sftpClient, c.node)
+	shell.ssh = client.Client
 	shell.Run()
 }
 
@@ -0,0 +1,434 @@
+package sshw
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// clusterEscape is the hotkey prefix of cluster mode (Ctrl-]).
+const clusterEscape = 0x1d
+
+// clusterScrollback is how much output of each host is kept to redraw the
+// screen when the host gets the focus.
+const clusterScrollback = 64 * 1024
+
+const clusterHelp = `cluster mode keys (after Ctrl-]):
+  b      toggle broadcast / type into the focused host only
+  n, p   focus the next / previous host
+  1-9    focus host number N
+  l      list hosts
+  q      close every session
+  Ctrl-] send a literal Ctrl-]`
+
+// clusterHost is one interactive session of a cluster.
+type clusterHost struct {
+	label   string
+	client  *sshClient
+	session *ssh.Session
+	stdin   io.WriteCloser
+	output  []byte // most recent output, up to clusterScrollback
+	unseen  bool   // output arrived while another host had the focus
+	closed  bool
+
+	inputMu sync.Mutex
+	input   []byte        // keystrokes not yet written to stdin
+	wake    chan struct{} // signals new input to feed
+}
+
+// send queues data for the stdin of the host without blocking.
+func (h *clusterHost) send(data []byte) {
+	h.inputMu.Lock()
+	h.input = append(h.input, data...)
+	h.inputMu.Unlock()
+	select {
+	case h.wake <- struct{}{}:
+	default:
+	}
+}
+
+// feed writes the queued input to stdin until quit is closed, so that a
+// host with a full window delays only its own input.
+func (h *clusterHost) feed(quit <-chan struct{}) {
+	for {
+		select {
+		case <-h.wake:
+		case <-quit:
+			return
+		}
+		h.inputMu.Lock()
+		data := h.input
+		h.input = nil
+		h.inputMu.Unlock()
+		if _, err := h.stdin.Write(data); err != nil {
+			return
+		}
+	}
+}
+
+// cluster fans the keystrokes of the local terminal out to several
+// interactive sessions and shows the output of the focused one.
+type cluster struct {
+	mu        sync.Mutex
+	hosts     []*clusterHost
+	focus     int
+	broadcast bool
+	escaped   bool
+	quit      chan struct{}
+	quitOnce  sync.Once
+}
+
+// Cluster opens an interactive shell on every node and broadcasts the local
+// keystrokes to all of them. The screen shows one host at a time; Ctrl-]
+// followed by a key switches the focused host or toggles broadcasting.
+func Cluster(nodes []*Node) {
+	pool := &hopPool{}
+	defer pool.Close()
+
+	clients := make([]*sshClient, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c := genSSHConfig(node)
+			if c == nil {
+				return
+			}
+			c.pool = pool
+			client, err := c.dial()
+			if err != nil {
+				l.Errorf("%s: %v", node.label(), err)
+				return
+			}
+			clients[i] = client
+		}()
+	}
+	wg.Wait()
+	defer func() {
+		for _, client := range clients {
+			if client != nil {
+				client.Close()
+			}
+		}
+	}()
+
+	fd := int(os.Stdin.Fd())
+	w, h, err := terminal.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		l.Error(err)
+		return
+	}
+
+	cl := &cluster{broadcast: true, quit: make(chan struct{})}
+	input := &rawPrompt{WriteCloser: cl}
+	for i, client := range clients {
+		if client == nil {
+			continue
+		}
+		host, err := cl.open(nodes[i], client, w, h, input.ask)
+		if err != nil {
+			l.Errorf("%s: %v", nodes[i].label(), err)
+			continue
+		}
+		cl.mu.Lock()
+		cl.hosts = append(cl.hosts, host)
+		cl.mu.Unlock()
+	}
+	if len(cl.hosts) == 0 {
+		l.Error("no session could be opened")
+		return
+	}
+
+	state, err := terminal.MakeRaw(fd)
+	if err != nil {
+		l.Error(err)
+		return
+	}
+	defer terminal.Restore(fd, state)
+
+	cl.mu.Lock()
+	cl.redraw()
+	cl.mu.Unlock()
+	rawInfof("broadcasting to %d host(s), press Ctrl-] ? for help", len(cl.hosts))
+
+	for _, host := range cl.hosts {
+		go func() {
+			host.session.Wait()
+			cl.closeHost(host)
+		}()
+	}
+
+	done := make(chan struct{})
+	go forwardInput(fd, input, done)
+	go cl.watchWindowSize(w, h)
+
+	<-cl.quit
+	close(done)
+	for _, host := range cl.hosts {
+		host.session.Close()
+	}
+}
+
+// open starts a shell on client with its output captured by the cluster.
+func (cl *cluster) open(node *Node, client *sshClient, w, h int, ask func(string) bool) (*clusterHost, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, err
+	}
+	modes := ssh.TerminalModes{
+		ssh.ECHO:          1,
+		ssh.TTY_OP_ISPEED: 14400,
+		ssh.TTY_OP_OSPEED: 14400,
+	}
+	if err := session.RequestPty("xterm", h, w, modes); err != nil {
+		session.Close()
+		return nil, err
+	}
+	host := &clusterHost{label: node.label(), client: client, session: session, wake: make(chan struct{}, 1)}
+	session.Stdout = &clusterOutput{cl: cl, host: host}
+	session.Stderr = session.Stdout
+	host.stdin, err = session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, err
+	}
+	if node.ForwardAgent {
+		if err := forwardAgent(client.Client, session, node, ask); err != nil {
+			l.Error(err)
+		}
+	}
+	if err := session.Shell(); err != nil {
+		session.Close()
+		return nil, err
+	}
+	go host.feed(cl.quit)
+	go func() {
+		for _, shell := range node.CallbackShells {
+			time.Sleep(shell.Delay * time.Millisecond)
+			host.send([]byte(shell.Cmd + "\r"))
+		}
+	}()
+	go keepAlive(client.Client)
+	return host, nil
+}
+
+// clusterOutput records the output of a host and shows it when the host
+// has the focus.
+type clusterOutput struct {
+	cl   *cluster
+	host *clusterHost
+}
+
+func (o *clusterOutput) Write(b []byte) (int, error) {
+	o.cl.mu.Lock()
+	defer o.cl.mu.Unlock()
+	h := o.host
+	h.output = append(h.output, b...)
+	if n := len(h.output); n > clusterScrollback {
+		h.output = append(h.output[:0], h.output[n-clusterScrollback:]...)
+	}
+	if o.cl.focus < len(o.cl.hosts) && o.cl.hosts[o.cl.focus] == h {
+		os.Stdout.Write(b)
+	} else {
+		h.unseen = true
+	}
+	return len(b), nil
+}
+
+// Write receives the local keystrokes and handles the hotkeys; everything
+// else goes to the focused host, or to every host when broadcasting.
+func (cl *cluster) Write(b []byte) (int, error) {
+	// every host has its own queue, so that a session with a full window
+	// blocks neither the output nor the input of the others
+	send := func(data []byte) {
+		if len(data) > 0 {
+			for _, h := range cl.targets() {
+				h.send(data)
+			}
+		}
+	}
+
+	cl.mu.Lock()
+	start := 0
+	for i, c := range b {
+		switch {
+		case cl.escaped:
+			cl.escaped = false
+			start = i + 1
+			if c == clusterEscape {
+				send([]byte{c})
+			} else {
+				cl.command(c)
+			}
+		case c == clusterEscape:
+			send(b[start:i])
+			cl.escaped = true
+		}
+	}
+	if !cl.escaped && start < len(b) {
+		send(b[start:])
+	}
+	cl.mu.Unlock()
+	return len(b), nil
+}
+
+func (cl *cluster) Close() error {
+	return nil
+}
+
+// targets returns the hosts that currently receive input. Callers hold
+// cl.mu.
+func (cl *cluster) targets() []*clusterHost {
+	var to []*clusterHost
+	for i, h := range cl.hosts {
+		if !h.closed && (cl.broadcast || i == cl.focus) {
+			to = append(to, h)
+		}
+	}
+	return to
+}
+
+// command runs the hotkey c. Callers hold cl.mu.
+func (cl *cluster) command(c byte) {
+	switch {
+	case c == 'b':
+		cl.broadcast = !cl.broadcast
+		if cl.broadcast {
+			rawInfof("broadcast on: typing into %d host(s)", cl.live())
+		} else {
+			rawInfof("broadcast off: typing into %s only", cl.hosts[cl.focus].label)
+		}
+	case c == 'n':
+		cl.switchTo(cl.next(cl.focus, 1))
+	case c == 'p':
+		cl.switchTo(cl.next(cl.focus, -1))
+	case c >= '1' && c <= '9':
+		if i := int(c - '1'); i < len(cl.hosts) && !cl.hosts[i].closed {
+			cl.switchTo(i)
+		}
+	case c == 'l':
+		cl.list()
+	case c == 'q':
+		cl.stop()
+	default:
+		fmt.Fprintf(os.Stderr, "\r\n%s\r\n", strings.ReplaceAll(clusterHelp, "\n", "\r\n"))
+	}
+}
+
+// next returns the index of the next host still open in direction dir.
+func (cl *cluster) next(from, dir int) int {
+	n := len(cl.hosts)
+	for i := 1; i <= n; i++ {
+		j := ((from+dir*i)%n + n) % n
+		if !cl.hosts[j].closed {
+			return j
+		}
+	}
+	return from
+}
+
+func (cl *cluster) live() int {
+	n := 0
+	for _, h := range cl.hosts {
+		if !h.closed {
+			n++
+		}
+	}
+	return n
+}
+
+// switchTo gives the focus to host i and redraws the screen with its recent
+// output.
+func (cl *cluster) switchTo(i int) {
+	if i == cl.focus {
+		return
+	}
+	cl.focus = i
+	cl.redraw()
+	mode := "broadcast on"
+	if !cl.broadcast {
+		mode = "broadcast off"
+	}
+	rawInfof("[%d/%d] %s, %s", i+1, len(cl.hosts), cl.hosts[i].label, mode)
+}
+
+func (cl *cluster) redraw() {
+	h := cl.hosts[cl.focus]
+	h.unseen = false
+	os.Stdout.WriteString("\x1b[H\x1b[2J")
+	os.Stdout.Write(h.output)
+}
+
+// list prints every host with its state; hosts with output the user has not
+// seen yet are marked with a star.
+func (cl *cluster) list() {
+	var b strings.Builder
+	b.WriteString("\r\n")
+	for i, h := range cl.hosts {
+		mark := " "
+		if i == cl.focus {
+			mark = ">"
+		}
+		state := "open"
+		if h.closed {
+			state = "closed"
+		}
+		if h.unseen {
+			state += " *"
+		}
+		fmt.Fprintf(&b, "%s %d %-20s %s\r\n", mark, i+1, h.label, state)
+	}
+	os.Stderr.WriteString(b.String())
+}
+
+// closeHost marks host as finished and moves the focus away from it, ending
+// cluster mode once no host is left.
+func (cl *cluster) closeHost(host *clusterHost) {
+	cl.mu.Lock()
+	defer cl.mu.Unlock()
+	host.closed = true
+	rawInfof("%s: session closed", host.label)
+	if cl.live() == 0 {
+		cl.stop()
+		return
+	}
+	if cl.hosts[cl.focus] == host {
+		cl.switchTo(cl.next(cl.focus, 1))
+	}
+}
+
+func (cl *cluster) stop() {
+	cl.quitOnce.Do(func() { close(cl.quit) })
+}
+
+// watchWindowSize reports local terminal size changes to every session.
+func (cl *cluster) watchWindowSize(w, h int) {
+	for {
+		select {
+		case <-cl.quit:
+			return
+		case <-time.After(time.Second):
+		}
+		cw, ch, err := terminal.GetSize(int(os.Stdout.Fd()))
+		if err != nil {
+			return
+		}
+		if cw != w || ch != h {
+			w, h = cw, ch
+			cl.mu.Lock()
+			for _, host := range cl.hosts {
+				if !host.closed {
+					host.session.WindowChange(h, w)
+				}
+			}
+			cl.mu.Unlock()
+		}
+	}
+}
@@ -2,9 +2,12 @@ package sshw
 
 import (
 	"fmt"
+	"net"
 	"os"
 	"os/user"
 	"path"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
@@ -16,17 +19,30 @@ import (
 )
 
 type Node struct {
-	Name           string           `yaml:"name"`
-	Alias          string           `yaml:"alias"`
-	Host           string           `yaml:"host"`
-	User           string           `yaml:"user"`
-	Port           int              `yaml:"port"`
-	KeyPath        string           `yaml:"keypath"`
-	Passphrase     string           `yaml:"passphrase"`
-	Password       string           `yaml:"password"`
-	CallbackShells []*CallbackShell `yaml:"callback-shells"`
-	Children       []*Node          `yaml:"children"`
-	Jump           []*Node          `yaml:"jump"`
+	Name                string            `yaml:"name"`
+	Alias               string            `yaml:"alias"`
+	Tags                []string          `yaml:"tags"`
+	Host                string            `yaml:"host"`
+	User                string            `yaml:"user"`
+	Port                int               `yaml:"port"`
+	KeyPath             string            `yaml:"keypath"`
+	Passphrase          string            `yaml:"passphrase"`
+	Password            string            `yaml:"password"`
+	ProxyCommand        string            `yaml:"proxy-command"`
+	Forwards            []*Forward        `yaml:"forwards"`
+	RemoteForwards      []*Forward        `yaml:"remote-forwards"`
+	DynamicForwards     []*DynamicForward `yaml:"dynamic-forwards"`
+	HostKeyPolicy       string            `yaml:"host-key-policy"`
+	UseAgent            *bool             `yaml:"use-agent"`
+	AgentIdentities     []string          `yaml:"agent-identities"`
+	ForwardAgent        bool              `yaml:"forward-agent"`
+	ForwardAgentConfirm bool              `yaml:"forward-agent-confirm"`
+	ForwardX11          bool              `yaml:"forward-x11"`
+	ForwardX11Trusted   bool              `yaml:"forward-x11-trusted"`
+	Preserve            bool              `yaml:"preserve"`
+	CallbackShells      []*CallbackShell  `yaml:"callback-shells"`
+	Children            []*Node           `yaml:"children"`
+	Jump                []*Node           `yaml:"jump"`
 }
 
 type CallbackShell struct {
@@ -52,6 +68,11 @@ func (n *Node) port() int {
 	return n.Port
 }
 
+// addr returns the host:port the node listens on.
+func (n *Node) addr() string {
+	return net.JoinHostPort(n.Host, strconv.Itoa(n.port()))
+}
+
 func (n *Node) password() ssh.AuthMethod {
 	if n.Password == "" {
 		return nil
@@ -59,6 +80,22 @@ func (n *Node) password() ssh.AuthMethod {
 	return ssh.Password(n.Password)
 }
 
+func (n *Node) useAgent() bool {
+	return n.UseAgent == nil || *n.UseAgent
+}
+
+func (n *Node) hostKeyPolicy() string {
+	switch n.HostKeyPolicy {
+	case "":
+		return HostKeyPolicyAsk
+	case HostKeyPolicyStrict, HostKeyPolicyAsk, HostKeyPolicyInsecure:
+		return n.HostKeyPolicy
+	default:
+		l.Errorf("unknown host-key-policy %q for %s, using %s", n.HostKeyPolicy, n.Name, HostKeyPolicyStrict)
+		return HostKeyPolicyStrict
+	}
+}
+
 func (n *Node) alias() string {
 	return n.Alias
 }
@@ -71,6 +108,71 @@ func GetConfig() []*Node {
 	return config
 }
 
+// FindNode returns the node addressed by name: the first node in the tree
+// whose alias is name, or else the node reached by a path of node names
+// separated by "/", starting at the top level, e.g. "group/child".
+func FindNode(nodes []*Node, name string) *Node {
+	if node := findAlias(nodes, name); node != nil {
+		return node
+	}
+	var node *Node
+	for _, part := range strings.Split(name, "/") {
+		node = nil
+		for _, n := range nodes {
+			if n.Name == part {
+				node = n
+				break
+			}
+		}
+		if node == nil {
+			return nil
+		}
+		nodes = node.Children
+	}
+	return node
+}
+
+func findAlias(nodes []*Node, alias string) *Node {
+	if alias == "" {
+		return nil
+	}
+	for _, node := range nodes {
+		if node.Alias == alias {
+			return node
+		}
+		if found := findAlias(node.Children, alias); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// NodeAddress returns a name that FindNode resolves to node: its alias when
+// it has one, otherwise its path of names. It returns "" when node is not
+// part of the tree or cannot be addressed unambiguously.
+func NodeAddress(nodes []*Node, node *Node) string {
+	if node.Alias != "" && findAlias(nodes, node.Alias) == node {
+		return node.Alias
+	}
+	path := nodePath(nodes, node)
+	if path == "" || FindNode(nodes, path) != node {
+		return ""
+	}
+	return path
+}
+
+func nodePath(nodes []*Node, node *Node) string {
+	for _, n := range nodes {
+		if n == node {
+			return n.Name
+		}
+		if sub := nodePath(n.Children, node); sub != "" {
+			return n.Name + "/" + sub
+		}
+	}
+	return ""
+}
+
 func LoadConfig(path string) error {
 	b, err := LoadConfigBytes(path, "~/.sshw", "~/.sshw.yml", "~/.sshw.yaml")
 	if err != nil {
@@ -102,6 +204,13 @@ func LoadSshConfig() error {
 		if err != nil {
 			return err
 		}
+		proxyCommand, _ := cfg.Get(alias, "ProxyCommand")
+		if proxyCommand == "none" {
+			proxyCommand = ""
+		}
+		if hostName == "" && proxyCommand != "" && !strings.ContainsAny(alias, "*?") {
+			hostName = alias
+		}
 		if hostName != "" {
 			port, _ := cfg.Get(alias, "Port")
 			if port == "" {
@@ -115,6 +224,7 @@ func LoadSshConfig() error {
 			c.Port, _ = strconv.Atoi(port)
 			keyPath, _ := cfg.Get(alias, "IdentityFile")
 			c.KeyPath, _ = homedir.Expand(keyPath)
+			c.ProxyCommand = proxyCommand
 			nc = append(nc, c)
 			// fmt.Println(c.Alias, c.Host, c.User, c.Port, c.KeyPath)
 		}
@@ -123,6 +233,24 @@ func LoadSshConfig() error {
 	return nil
 }
 
+// stateDir returns a path inside sshw's state directory (~/.sshw.d).
+func stateDir(elem ...string) (string, error) {
+	dir, err := homedir.Expand("~/.sshw.d")
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(append([]string{dir}, elem...)...), nil
+}
+
+var unsafeFileChars = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+
+// safeFileName turns name into a file name for the state directory by
+// replacing every character but letters, digits, dots, dashes and
+// underscores with an underscore.
+func safeFileName(name string) string {
+	return unsafeFileChars.ReplaceAllString(name, "_")
+}
+
 func LoadConfigBytes(names ...string) ([]byte, error) {
 	for i := range names {
 		path := names[i]
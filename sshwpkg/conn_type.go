@@ -6,6 +6,10 @@ type ConnType int
 const (
 	ConnTypeSSH ConnType = iota
 	ConnTypeSFTP
+	ConnTypeSOCKS
+	ConnTypeTunnel
+	ConnTypeCluster
+	ConnTypeTmux
 )
 
 func (c ConnType) String() string {
@@ -14,6 +18,14 @@ func (c ConnType) String() string {
 		return "SSH"
 	case ConnTypeSFTP:
 		return "SFTP"
+	case ConnTypeSOCKS:
+		return "SOCKS"
+	case ConnTypeTunnel:
+		return "Tunnel"
+	case ConnTypeCluster:
+		return "Cluster"
+	case ConnTypeTmux:
+		return "Tmux"
 	default:
 		return "Unknown"
 	}
@@ -25,6 +37,14 @@ func (c ConnType) Description() string {
 		return "Interactive SSH Shell"
 	case ConnTypeSFTP:
 		return "Interactive SFTP File Transfer"
+	case ConnTypeSOCKS:
+		return "SOCKS5 Proxy (no shell)"
+	case ConnTypeTunnel:
+		return "Port Forwards Only (live status)"
+	case ConnTypeCluster:
+		return "Broadcast Keystrokes to Several Hosts"
+	case ConnTypeTmux:
+		return "Open Several Hosts in tmux Windows or Panes"
 	default:
 		return ""
 	}
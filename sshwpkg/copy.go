@@ -0,0 +1,307 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// CopyOptions controls Copy.
+type CopyOptions struct {
+	// Recursive copies directories with their contents.
+	Recursive bool
+	// Resume continues partially copied target files instead of copying
+	// them again.
+	Resume bool
+	// Verify compares checksums of the part already copied before a copy
+	// is resumed.
+	Verify bool
+	// Preserve keeps the mode and the access and modification times of the
+	// sources. It is the default for nodes with preserve set.
+	Preserve bool
+}
+
+// transfer returns the options of a single file transfer over conn between
+// nodes; Preserve is turned on by any of them.
+func (o CopyOptions) transfer(conn *sftpConn, nodes ...*Node) transferOptions {
+	preserve := o.Preserve
+	for _, node := range nodes {
+		preserve = preserve || node.Preserve
+	}
+	return transferOptions{resume: o.Resume, verify: o.Verify, ssh: conn.ssh.Client, preserve: preserve}
+}
+
+// endpoint is an operand of Copy: a local path, or a path on a node when
+// written as address:path.
+type endpoint struct {
+	node *Node
+	path string
+}
+
+func (e endpoint) String() string {
+	if e.node == nil {
+		return e.path
+	}
+	return e.node.label() + ":" + e.path
+}
+
+// parseEndpoint splits address:path operands. The part before the first
+// colon must resolve to a node with FindNode; anything else is a local path.
+func parseEndpoint(arg string) endpoint {
+	if i := strings.Index(arg, ":"); i > 0 {
+		if node := FindNode(config, arg[:i]); node != nil {
+			return endpoint{node: node, path: arg[i+1:]}
+		}
+	}
+	return endpoint{path: arg}
+}
+
+// Copy copies every source to target like scp. Operands are local paths or
+// address:path on a configured node; relative remote paths start at the
+// login directory. With several sources target must be a directory. A
+// failing source does not stop the others, and an error is returned when any
+// of them failed.
+func Copy(sources []string, target string, opts CopyOptions) error {
+	conns := &sftpConns{pool: &hopPool{}}
+	defer conns.Close()
+
+	dst := parseEndpoint(target)
+	dstDir, err := conns.isDir(dst)
+	if err != nil {
+		return err
+	}
+	if len(sources) > 1 && !dstDir {
+		return fmt.Errorf("target %s is not a directory", dst)
+	}
+
+	failed := 0
+	for _, source := range sources {
+		errs := conns.copy(parseEndpoint(source), dst, dstDir, opts)
+		for _, err := range errs {
+			l.Error(err)
+		}
+		if len(errs) > 0 {
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d source(s) failed", failed, len(sources))
+	}
+	return nil
+}
+
+// sftpConns keeps one sftp session per node for the duration of a copy.
+type sftpConns struct {
+	pool  *hopPool
+	conns map[*Node]*sftpConn
+}
+
+type sftpConn struct {
+	ssh  *sshClient
+	sftp *sftp.Client
+}
+
+// get returns the sftp client of node, connecting on first use.
+func (s *sftpConns) get(node *Node) (*sftp.Client, error) {
+	conn, err := s.conn(node)
+	if err != nil {
+		return nil, err
+	}
+	return conn.sftp, nil
+}
+
+// conn returns the connection to node, connecting on first use.
+func (s *sftpConns) conn(node *Node) (*sftpConn, error) {
+	if conn, ok := s.conns[node]; ok {
+		return conn, nil
+	}
+	c := genSSHConfig(node)
+	if c == nil {
+		return nil, errors.New("cannot build ssh config")
+	}
+	c.pool = s.pool
+	client, err := c.dial()
+	if err != nil {
+		return nil, err
+	}
+	sftpClient, err := NewSFTPClient(client.Client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	if s.conns == nil {
+		s.conns = make(map[*Node]*sftpConn)
+	}
+	conn := &sftpConn{ssh: client, sftp: sftpClient}
+	s.conns[node] = conn
+	return conn, nil
+}
+
+func (s *sftpConns) Close() {
+	for _, conn := range s.conns {
+		conn.sftp.Close()
+		conn.ssh.Close()
+	}
+	s.pool.Close()
+}
+
+// remotePath makes p absolute on client, resolving "" and "~" to the login
+// directory.
+func remotePath(client *sftp.Client, p string) (string, error) {
+	switch {
+	case p == "" || p == "~":
+		p = "."
+	case strings.HasPrefix(p, "~/"):
+		p = p[2:]
+	}
+	if path.IsAbs(p) {
+		return p, nil
+	}
+	wd, err := client.Getwd()
+	if err != nil {
+		return "", err
+	}
+	return path.Join(wd, p), nil
+}
+
+// isDir reports whether e is an existing directory.
+func (s *sftpConns) isDir(e endpoint) (bool, error) {
+	if e.node == nil {
+		info, err := os.Stat(e.path)
+		return err == nil && info.IsDir(), nil
+	}
+	client, err := s.get(e.node)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", e.node.label(), err)
+	}
+	p, err := remotePath(client, e.path)
+	if err != nil {
+		return false, err
+	}
+	info, err := client.Stat(p)
+	return err == nil && info.IsDir(), nil
+}
+
+// copy copies src to dst, into dst when dstDir is set, and returns every
+// error encountered.
+func (s *sftpConns) copy(src, dst endpoint, dstDir bool, opts CopyOptions) []error {
+	switch {
+	case src.node == nil && dst.node == nil:
+		return []error{fmt.Errorf("%s and %s are both local", src, dst)}
+	case src.node != nil && dst.node != nil:
+		return s.relay(src, dst, dstDir, opts)
+	case src.node != nil:
+		return s.download(src, dst, dstDir, opts)
+	default:
+		return s.upload(src, dst, dstDir, opts)
+	}
+}
+
+func (s *sftpConns) download(src, dst endpoint, dstDir bool, opts CopyOptions) []error {
+	conn, err := s.conn(src.node)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", src.node.label(), err)}
+	}
+	client := conn.sftp
+	from, err := remotePath(client, src.path)
+	if err != nil {
+		return []error{err}
+	}
+	info, err := client.Stat(from)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", src, err)}
+	}
+	to := dst.path
+	if dstDir {
+		to = filepath.Join(to, path.Base(from))
+	}
+
+	if !info.IsDir() {
+		if _, err := download(client, from, to, opts.transfer(conn, src.node)); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if !opts.Recursive {
+		return []error{fmt.Errorf("%s is a directory (use -r)", src)}
+	}
+	_, _, errs := downloadTree(client, from, to, opts.transfer(conn, src.node))
+	return errs
+}
+
+func (s *sftpConns) upload(src, dst endpoint, dstDir bool, opts CopyOptions) []error {
+	conn, err := s.conn(dst.node)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", dst.node.label(), err)}
+	}
+	client := conn.sftp
+	info, err := os.Stat(src.path)
+	if err != nil {
+		return []error{err}
+	}
+	to, err := remotePath(client, dst.path)
+	if err != nil {
+		return []error{err}
+	}
+	if dstDir {
+		name := src.path
+		if abs, err := filepath.Abs(name); err == nil {
+			name = abs
+		}
+		to = path.Join(to, filepath.Base(name))
+	}
+
+	if !info.IsDir() {
+		if _, err := upload(client, src.path, to, opts.transfer(conn, dst.node)); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if !opts.Recursive {
+		return []error{fmt.Errorf("%s is a directory (use -r)", src)}
+	}
+	_, _, errs := uploadTree(client, src.path, to, opts.transfer(conn, dst.node))
+	return errs
+}
+
+func (s *sftpConns) relay(src, dst endpoint, dstDir bool, opts CopyOptions) []error {
+	from, err := s.conn(src.node)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", src.node.label(), err)}
+	}
+	to, err := s.conn(dst.node)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", dst.node.label(), err)}
+	}
+	fromPath, err := remotePath(from.sftp, src.path)
+	if err != nil {
+		return []error{err}
+	}
+	toPath, err := remotePath(to.sftp, dst.path)
+	if err != nil {
+		return []error{err}
+	}
+	info, err := from.sftp.Stat(fromPath)
+	if err != nil {
+		return []error{fmt.Errorf("%s: %w", src, err)}
+	}
+	if dstDir {
+		toPath = path.Join(toPath, path.Base(fromPath))
+	}
+
+	if !info.IsDir() {
+		if _, err := relay(from, fromPath, to, toPath, opts.transfer(to, src.node, dst.node)); err != nil {
+			return []error{err}
+		}
+		return nil
+	}
+	if !opts.Recursive {
+		return []error{fmt.Errorf("%s is a directory (use -r)", src)}
+	}
+	return relayTree(from, fromPath, to, toPath, opts.transfer(to, src.node, dst.node))
+}
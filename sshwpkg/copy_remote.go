@@ -0,0 +1,168 @@
+package sshw
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// relay streams the file from on src to to on dst through sshw without
+// touching the local disk, then verifies the copy by comparing SHA-256
+// checksums. With opts.resume a shorter target is continued instead of being
+// copied again. Progress is added to opts.bar, or shown on a bar of its own
+// when it is nil.
+func relay(src *sftpConn, from string, dst *sftpConn, to string, opts transferOptions) (int64, error) {
+	srcFile, err := src.sftp.Open(from)
+	if err != nil {
+		return 0, fmt.Errorf("opening %s: %w", from, err)
+	}
+	defer srcFile.Close()
+	info, err := srcFile.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.resume {
+		if st, err := dst.sftp.Stat(to); err == nil {
+			if offset, err = resumeOffset(size, st); err != nil {
+				return 0, err
+			}
+			flags = os.O_WRONLY
+		}
+		if offset > 0 && opts.verify {
+			srcSum, dstSum, err := checksums(src, from, dst, to, offset)
+			if err != nil {
+				return 0, err
+			}
+			if srcSum != dstSum {
+				return 0, fmt.Errorf("the first %d bytes of %s and %s differ, copy it again without resuming", offset, from, to)
+			}
+		}
+	}
+	dstFile, err := dst.sftp.OpenFile(to, flags)
+	if err != nil {
+		return 0, fmt.Errorf("creating %s: %w", to, err)
+	}
+	defer dstFile.Close()
+
+	var n int64
+	if !skipDone(to, offset, size, opts.bar) {
+		if err := seekBoth(srcFile, dstFile, offset); err != nil {
+			return 0, err
+		}
+
+		// concurrent reads on one side feed concurrent writes on the other
+		pr, pw := io.Pipe()
+		go func() {
+			_, err := srcFile.WriteTo(pw)
+			pw.CloseWithError(err)
+		}()
+		description := fmt.Sprintf("%s %s", verb("Copying", offset), path.Base(from))
+		n, err = dstFile.ReadFrom(&progressReader{reader: pr, total: size - offset, description: description, bar: opts.bar})
+		pr.Close()
+		if opts.bar == nil {
+			fmt.Fprint(os.Stderr, "\n")
+		}
+		if err != nil {
+			return n, fmt.Errorf("copying %s: %w", from, err)
+		}
+	}
+	if err := dstFile.Close(); err != nil {
+		return n, err
+	}
+
+	srcSum, dstSum, err := checksums(src, from, dst, to, -1)
+	switch {
+	case err != nil:
+		return n, err
+	case srcSum != dstSum:
+		if offset > 0 {
+			return n, fmt.Errorf("checksum mismatch after resuming %s, copy it again without -a", to)
+		}
+		return n, fmt.Errorf("checksum mismatch: %s %s, %s %s", from, srcSum, to, dstSum)
+	}
+	if opts.preserve {
+		return n, preserveRemote(dst.sftp, to, info, remoteAtime(info))
+	}
+	return n, nil
+}
+
+// relayTree copies the directory tree fromDir on src to toDir on dst with
+// one progress bar for all files. A file that fails does not stop the
+// transfer; every failure is returned.
+func relayTree(src *sftpConn, fromDir string, dst *sftpConn, toDir string, opts transferOptions) (errs []error) {
+	opts.bar = newProgressBar(remoteTreeSize(src.sftp, fromDir), fmt.Sprintf("Copying %s", path.Base(fromDir)))
+	defer finishBar(opts.bar)
+
+	var dirs []preservedDir
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := preserveRemote(dst.sftp, dirs[i].path, dirs[i].info, remoteAtime(dirs[i].info)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	walker := src.sftp.Walk(fromDir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rel, err := relPath(fromDir, walker.Path())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		to := path.Join(toDir, rel)
+
+		info := walker.Stat()
+		mode := info.Mode()
+		if mode&os.ModeSymlink != 0 {
+			if target, err := src.sftp.Stat(walker.Path()); err == nil && target.Mode().IsRegular() {
+				mode = target.Mode()
+			}
+		}
+		switch {
+		case info.IsDir():
+			if err := dst.sftp.MkdirAll(to); err != nil {
+				errs = append(errs, fmt.Errorf("creating remote directory %s: %w", to, err))
+				walker.SkipDir()
+			} else if opts.preserve {
+				dirs = append(dirs, preservedDir{to, info})
+			}
+		case mode.IsRegular():
+			if _, err := relay(src, walker.Path(), dst, to, opts); err != nil {
+				errs = append(errs, err)
+			}
+		default:
+			errs = append(errs, fmt.Errorf("%s: skipping %s", walker.Path(), fileKind(mode)))
+		}
+	}
+	return errs
+}
+
+// checksums computes the SHA-256 checksums of from on src and to on dst
+// concurrently, of their first n bytes when n is not negative.
+func checksums(src *sftpConn, from string, dst *sftpConn, to string, n int64) (string, string, error) {
+	sums := make(chan string, 1)
+	errs := make(chan error, 1)
+	go func() {
+		sum, err := remoteSHA256(src.sftp, src.ssh.Client, from, n)
+		sums <- sum
+		errs <- err
+	}()
+	dstSum, dstErr := remoteSHA256(dst.sftp, dst.ssh.Client, to, n)
+	srcSum, srcErr := <-sums, <-errs
+	switch {
+	case srcErr != nil:
+		return "", "", fmt.Errorf("checksum of %s: %w", from, srcErr)
+	case dstErr != nil:
+		return "", "", fmt.Errorf("checksum of %s: %w", to, dstErr)
+	}
+	return srcSum, dstSum, nil
+}
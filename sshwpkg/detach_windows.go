@@ -0,0 +1,19 @@
+//go:build windows
+
+package sshw
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// detachedProcess is DETACHED_PROCESS from the Windows API.
+const detachedProcess = 0x00000008
+
+// detach starts cmd without a console so it survives the terminal closing.
+func detach(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP | detachedProcess,
+		HideWindow:    true,
+	}
+}
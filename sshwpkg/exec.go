@@ -0,0 +1,119 @@
+package sshw
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// execFailed is the exit code used when the command could not be run at
+// all, the same as OpenSSH's ssh client.
+const execFailed = 255
+
+// Exec runs command on the node with its output streamed to stdout and
+// stderr, and returns the remote exit status. Local stdin is piped to the
+// command when it is not a terminal. A pty is only allocated when tty is
+// set, in which case a terminal stdin is forwarded in raw mode.
+func (c *defaultClient) Exec(command string, tty bool) int {
+	client := c.createSSHClient()
+	if client == nil {
+		return execFailed
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		l.Error(err)
+		return execFailed
+	}
+	defer session.Close()
+
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+
+	fd := int(os.Stdin.Fd())
+	interactive := terminal.IsTerminal(fd)
+	ask := func(question string) bool {
+		promptMu.Lock()
+		defer promptMu.Unlock()
+		return confirm(question + " (yes/no)? ")
+	}
+
+	var input *rawPrompt
+	if tty {
+		w, h := 80, 24
+		if interactive {
+			if cw, ch, err := terminal.GetSize(int(os.Stdout.Fd())); err == nil {
+				w, h = cw, ch
+			}
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty("xterm", h, w, modes); err != nil {
+			l.Error(err)
+			return execFailed
+		}
+		if interactive {
+			stdinPipe, err := session.StdinPipe()
+			if err != nil {
+				l.Error(err)
+				return execFailed
+			}
+			input = &rawPrompt{WriteCloser: stdinPipe}
+			ask = input.ask
+			go watchWindowSize(session, w, h)
+		}
+	}
+	if !interactive {
+		session.Stdin = os.Stdin
+	}
+
+	if c.node.ForwardAgent {
+		if err := forwardAgent(client.Client, session, c.node, ask); err != nil {
+			l.Error(err)
+		}
+	}
+
+	if input != nil {
+		state, err := terminal.MakeRaw(fd)
+		if err != nil {
+			l.Error(err)
+			return execFailed
+		}
+		defer terminal.Restore(fd, state)
+
+		done := make(chan struct{})
+		defer close(done)
+		go forwardInput(fd, input, done)
+	}
+
+	go keepAlive(client.Client)
+
+	return exitStatus(session.Run(command))
+}
+
+// ShellQuote quotes s as a single word for a POSIX shell, the shell remote
+// commands and tmux windows are run with.
+func ShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// exitStatus converts the result of a remote command into a process exit
+// code.
+func exitStatus(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus()
+	}
+	l.Error(err)
+	return execFailed
+}
@@ -0,0 +1,285 @@
+package sshw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// FleetResult is the outcome of a command run on one node of a fleet.
+type FleetResult struct {
+	Node *Node
+	// Status is the remote exit status, or 255 when the command did not run.
+	Status int
+	// Err is set when the node could not be reached or the command could
+	// not be started.
+	Err error
+	// Stdout and Stderr hold the output of the command when it was gathered
+	// instead of streamed.
+	Stdout, Stderr []byte
+	Duration       time.Duration
+}
+
+// FindTargets returns the leaf nodes addressed by target: the leaves of the
+// node FindNode resolves target to, of every node named target and of every
+// node tagged with it. Each leaf is returned once, in inventory order.
+func FindTargets(nodes []*Node, target string) []*Node {
+	var leaves []*Node
+	seen := make(map[*Node]bool)
+	addressed := FindNode(nodes, target)
+	var walk func(nodes []*Node, matched bool)
+	walk = func(nodes []*Node, matched bool) {
+		for _, node := range nodes {
+			m := matched || node == addressed || node.Name == target || node.hasTag(target)
+			if len(node.Children) > 0 {
+				walk(node.Children, m)
+				continue
+			}
+			if m && !seen[node] {
+				seen[node] = true
+				leaves = append(leaves, node)
+			}
+		}
+	}
+	walk(nodes, false)
+	return leaves
+}
+
+func (n *Node) hasTag(tag string) bool {
+	for _, t := range n.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// label is the short name used to prefix the node's output.
+func (n *Node) label() string {
+	switch {
+	case n.Alias != "":
+		return n.Alias
+	case n.Name != "":
+		return n.Name
+	default:
+		return n.Host
+	}
+}
+
+// ExecFleet runs command on every node, at most parallel at a time, and
+// streams their stdout and stderr line by line prefixed with the node label,
+// or keeps it in the results when gather is set. Jump hops shared by several
+// nodes are dialed once for the whole run.
+func ExecFleet(nodes []*Node, command string, parallel int, gather bool) []FleetResult {
+	if parallel < 1 {
+		parallel = 1
+	}
+	width := 0
+	for _, node := range nodes {
+		if len(node.label()) > width {
+			width = len(node.label())
+		}
+	}
+
+	pool := &hopPool{}
+	defer pool.Close()
+
+	var outMu sync.Mutex
+	results := make([]FleetResult, len(nodes))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			start := time.Now()
+			if gather {
+				var stdout, stderr bytes.Buffer
+				status, err := runOn(node, pool, command, &stdout, &stderr)
+				results[i] = FleetResult{Node: node, Status: status, Err: err, Stdout: stdout.Bytes(), Stderr: stderr.Bytes()}
+			} else {
+				prefix := fmt.Sprintf("%-*s | ", width, node.label())
+				stdout := &prefixWriter{mu: &outMu, w: os.Stdout, prefix: prefix}
+				stderr := &prefixWriter{mu: &outMu, w: os.Stderr, prefix: prefix}
+				status, err := runOn(node, pool, command, stdout, stderr)
+				stdout.Flush()
+				stderr.Flush()
+				results[i] = FleetResult{Node: node, Status: status, Err: err}
+			}
+			results[i].Duration = time.Since(start)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// runOn runs command on node and returns its exit status. Connection and
+// session errors are returned with status 255.
+func runOn(node *Node, pool *hopPool, command string, stdout, stderr io.Writer) (int, error) {
+	c := genSSHConfig(node)
+	if c == nil {
+		return execFailed, errors.New("cannot build ssh config")
+	}
+	c.pool = pool
+	client, err := c.dial()
+	if err != nil {
+		return execFailed, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return execFailed, err
+	}
+	defer session.Close()
+	session.Stdout = stdout
+	session.Stderr = stderr
+
+	if node.ForwardAgent {
+		ask := func(question string) bool {
+			promptMu.Lock()
+			defer promptMu.Unlock()
+			return confirm(question + " (yes/no)? ")
+		}
+		if err := forwardAgent(client.Client, session, node, ask); err != nil {
+			fmt.Fprintln(stderr, err)
+		}
+	}
+
+	err = session.Run(command)
+	if err == nil {
+		return 0, nil
+	}
+	var exitErr *ssh.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitStatus(), nil
+	}
+	return execFailed, err
+}
+
+// FleetExitCode returns the highest exit status of results, so that a fleet
+// run only succeeds when every node succeeded.
+func FleetExitCode(results []FleetResult) int {
+	code := 0
+	for _, r := range results {
+		if r.Status > code {
+			code = r.Status
+		}
+	}
+	return code
+}
+
+// PrintFleetSummary writes the status of every node followed by the totals.
+func PrintFleetSummary(out io.Writer, results []FleetResult) {
+	ok := 0
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NODE\tSTATUS\tEXIT\tERROR")
+	for _, r := range results {
+		status := "ok"
+		switch {
+		case r.Err != nil:
+			status = "error"
+		case r.Status != 0:
+			status = "failed"
+		default:
+			ok++
+		}
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", r.Node.label(), status, r.Status, errText)
+	}
+	w.Flush()
+	fmt.Fprintf(out, "%d node(s): %d ok, %d failed\n", len(results), ok, len(results)-ok)
+}
+
+// prefixWriter writes every complete line with prefix. Writers sharing mu
+// never interleave within a line.
+type prefixWriter struct {
+	mu     *sync.Mutex
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		p.writeLine(p.buf[:i+1])
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+// Flush writes a trailing partial line, terminating it with a newline.
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		p.writeLine(append(p.buf, '\n'))
+		p.buf = nil
+	}
+}
+
+func (p *prefixWriter) writeLine(line []byte) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	io.WriteString(p.w, p.prefix)
+	p.w.Write(line)
+}
+
+// hopPool shares jump hop connections between the nodes of a fleet run, so
+// a bastion in front of many nodes is dialed and authenticated once. Hops
+// are keyed by the chain leading to them; a failed dial is not retried.
+type hopPool struct {
+	mu   sync.Mutex
+	hops map[string]*pooledHop
+}
+
+type pooledHop struct {
+	once   sync.Once
+	client *ssh.Client
+	err    error
+}
+
+func (p *hopPool) get(key string, dial func() (*ssh.Client, error)) (*ssh.Client, error) {
+	p.mu.Lock()
+	if p.hops == nil {
+		p.hops = make(map[string]*pooledHop)
+	}
+	hop, ok := p.hops[key]
+	if !ok {
+		hop = &pooledHop{}
+		p.hops[key] = hop
+	}
+	p.mu.Unlock()
+
+	hop.once.Do(func() {
+		hop.client, hop.err = dial()
+	})
+	return hop.client, hop.err
+}
+
+// Close closes every pooled hop connection.
+func (p *hopPool) Close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for _, hop := range p.hops {
+		if hop.client != nil {
+			hop.client.Close()
+		}
+	}
+}
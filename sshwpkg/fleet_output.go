@@ -0,0 +1,126 @@
+package sshw
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// outputGroup is a set of nodes that returned the same output and status.
+type outputGroup struct {
+	nodes  []string
+	result FleetResult
+}
+
+// groupResults groups results with identical stdout, stderr, exit status and
+// error, largest group first and in inventory order among equal sizes.
+func groupResults(results []FleetResult) []*outputGroup {
+	var groups []*outputGroup
+	byKey := make(map[string]*outputGroup)
+	for _, r := range results {
+		errText := ""
+		if r.Err != nil {
+			errText = r.Err.Error()
+		}
+		key := fmt.Sprintf("%d\x00%s\x00%s\x00%s", r.Status, errText, r.Stdout, r.Stderr)
+		g, ok := byKey[key]
+		if !ok {
+			g = &outputGroup{result: r}
+			byKey[key] = g
+			groups = append(groups, g)
+		}
+		g.nodes = append(g.nodes, r.Node.label())
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i].nodes) > len(groups[j].nodes)
+	})
+	return groups
+}
+
+// PrintFleetGathered writes the gathered results with nodes that returned
+// identical output folded into one block, like dshbak -c or clush -b. When
+// the nodes disagree, every block but the most common one is highlighted.
+func PrintFleetGathered(out io.Writer, results []FleetResult) {
+	color := false
+	if f, ok := out.(*os.File); ok {
+		color = terminal.IsTerminal(int(f.Fd()))
+	}
+
+	groups := groupResults(results)
+	for i, g := range groups {
+		r := g.result
+		header := fmt.Sprintf("%s (%d node(s))", strings.Join(g.nodes, ","), len(g.nodes))
+		switch {
+		case r.Err != nil:
+			header += ", error"
+		case r.Status != 0:
+			header += fmt.Sprintf(", exit %d", r.Status)
+		}
+		if i > 0 {
+			header += ", differs"
+		}
+		rule := strings.Repeat("-", len(header))
+		if i > 0 && color {
+			header = "\x1b[1;33m" + header + "\x1b[0m"
+		}
+		fmt.Fprintf(out, "%s\n%s\n%s\n", rule, header, rule)
+
+		out.Write(r.Stdout)
+		if len(r.Stdout) > 0 && r.Stdout[len(r.Stdout)-1] != '\n' {
+			fmt.Fprintln(out)
+		}
+		out.Write(r.Stderr)
+		if len(r.Stderr) > 0 && r.Stderr[len(r.Stderr)-1] != '\n' {
+			fmt.Fprintln(out)
+		}
+		if r.Err != nil {
+			fmt.Fprintln(out, r.Err)
+		}
+	}
+	if len(groups) == 1 {
+		fmt.Fprintf(out, "\nall %d node(s) returned the same output\n", len(results))
+	} else if len(groups) > 1 {
+		fmt.Fprintf(out, "\n%d different outputs from %d node(s)\n", len(groups), len(results))
+	}
+}
+
+// fleetJSON is the JSON form of a FleetResult.
+type fleetJSON struct {
+	Node       string `json:"node"`
+	Name       string `json:"name"`
+	Host       string `json:"host"`
+	ExitStatus int    `json:"exit_status"`
+	Error      string `json:"error,omitempty"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// PrintFleetJSON writes the gathered results as a JSON array, one object per
+// node in inventory order.
+func PrintFleetJSON(out io.Writer, results []FleetResult) error {
+	list := make([]fleetJSON, 0, len(results))
+	for _, r := range results {
+		j := fleetJSON{
+			Node:       r.Node.label(),
+			Name:       r.Node.Name,
+			Host:       r.Node.Host,
+			ExitStatus: r.Status,
+			Stdout:     string(r.Stdout),
+			Stderr:     string(r.Stderr),
+			DurationMs: r.Duration.Milliseconds(),
+		}
+		if r.Err != nil {
+			j.Error = r.Err.Error()
+		}
+		list = append(list, j)
+	}
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(list)
+}
@@ -0,0 +1,139 @@
+package sshw
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func fleetResult(name, stdout string, status int) FleetResult {
+	return FleetResult{
+		Node:     &Node{Name: name, Host: name + ".example.com"},
+		Status:   status,
+		Stdout:   []byte(stdout),
+		Duration: 1500 * time.Millisecond,
+	}
+}
+
+func TestGroupResults(t *testing.T) {
+	results := []FleetResult{
+		fleetResult("web1", "ok\n", 0),
+		fleetResult("web2", "ok\n", 0),
+		fleetResult("web3", "disk full\n", 1),
+		fleetResult("web4", "ok\n", 0),
+		// same output as the first group but a different exit status
+		fleetResult("web5", "ok\n", 1),
+	}
+	groups := groupResults(results)
+
+	var got [][]string
+	for _, g := range groups {
+		got = append(got, g.nodes)
+	}
+	want := [][]string{{"web1", "web2", "web4"}, {"web3"}, {"web5"}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %v, want %v", got, want)
+	}
+	if s := string(groups[1].result.Stdout); s != "disk full\n" {
+		t.Errorf("differing group output %q", s)
+	}
+}
+
+func TestGroupResultsError(t *testing.T) {
+	a := fleetResult("a", "", execFailed)
+	a.Err = errors.New("connection refused")
+	b := fleetResult("b", "", execFailed)
+	b.Err = errors.New("no route to host")
+	c := fleetResult("c", "", execFailed)
+	c.Err = errors.New("connection refused")
+	groups := groupResults([]FleetResult{a, b, c})
+	if len(groups) != 2 || !reflect.DeepEqual(groups[0].nodes, []string{"a", "c"}) {
+		t.Errorf("nodes with different errors were grouped together")
+	}
+}
+
+func TestPrintFleetGathered(t *testing.T) {
+	results := []FleetResult{
+		fleetResult("web1", "ok\n", 0),
+		fleetResult("web2", "disk full", 1),
+		fleetResult("web3", "ok\n", 0),
+	}
+	var out bytes.Buffer
+	PrintFleetGathered(&out, results)
+	same := "web1,web3 (2 node(s))"
+	differs := "web2 (1 node(s)), exit 1, differs"
+	want := strings.Join([]string{
+		strings.Repeat("-", len(same)), same, strings.Repeat("-", len(same)),
+		"ok",
+		strings.Repeat("-", len(differs)), differs, strings.Repeat("-", len(differs)),
+		"disk full",
+		"",
+		"2 different outputs from 3 node(s)",
+		"",
+	}, "\n")
+	if got := out.String(); got != want {
+		t.Errorf("output\n%s\nwant\n%s", got, want)
+	}
+	if strings.Contains(out.String(), "\x1b[") {
+		t.Error("color written to a non-terminal")
+	}
+
+	out.Reset()
+	PrintFleetGathered(&out, results[:1])
+	if !strings.HasSuffix(out.String(), "\nall 1 node(s) returned the same output\n") {
+		t.Errorf("missing summary in %q", out.String())
+	}
+}
+
+func TestPrintFleetJSON(t *testing.T) {
+	ok := fleetResult("web1", "ok\n", 0)
+	ok.Node.Alias = "w1"
+	failed := fleetResult("web2", "", execFailed)
+	failed.Stderr = []byte("oops\n")
+	failed.Err = errors.New("connection refused")
+
+	var out bytes.Buffer
+	if err := PrintFleetJSON(&out, []FleetResult{ok, failed}); err != nil {
+		t.Fatal(err)
+	}
+	var got []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v\n%s", err, out.String())
+	}
+	want := []map[string]interface{}{
+		{
+			"node":        "w1",
+			"name":        "web1",
+			"host":        "web1.example.com",
+			"exit_status": float64(0),
+			"stdout":      "ok\n",
+			"stderr":      "",
+			"duration_ms": float64(1500),
+		},
+		{
+			"node":        "web2",
+			"name":        "web2",
+			"host":        "web2.example.com",
+			"exit_status": float64(execFailed),
+			"error":       "connection refused",
+			"stdout":      "",
+			"stderr":      "oops\n",
+			"duration_ms": float64(1500),
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("JSON = %v, want %v", got, want)
+	}
+
+	out.Reset()
+	if err := PrintFleetJSON(&out, nil); err != nil {
+		t.Fatal(err)
+	}
+	if s := strings.TrimSpace(out.String()); s != "[]" {
+		t.Errorf("empty results = %q, want []", s)
+	}
+}
@@ -0,0 +1,158 @@
+package sshw
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fleetInventory is a small tree of groups, aliased nodes and tags.
+func fleetInventory() []*Node {
+	return []*Node{
+		{Name: "web", Children: []*Node{
+			{Name: "web1", Alias: "w1", Host: "10.0.0.1", Tags: []string{"app", "eu"}},
+			{Name: "web2", Alias: "w2", Host: "10.0.0.2", Tags: []string{"app"}},
+		}},
+		{Name: "db", Children: []*Node{
+			{Name: "primary", Alias: "db1", Host: "10.0.1.1", Tags: []string{"eu"}},
+			{Name: "replica", Host: "10.0.1.2"},
+		}},
+		{Name: "primary", Alias: "bastion", Host: "10.0.2.1"},
+		{Name: "app", Host: "10.0.3.1"},
+	}
+}
+
+func TestFindTargets(t *testing.T) {
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{target: "web", want: []string{"10.0.0.1", "10.0.0.2"}},
+		{target: "w2", want: []string{"10.0.0.2"}},
+		{target: "db/replica", want: []string{"10.0.1.2"}},
+		// every node named primary, the nested one and the top level one
+		{target: "primary", want: []string{"10.0.1.1", "10.0.2.1"}},
+		// the tag and the node named like it, in inventory order
+		{target: "app", want: []string{"10.0.0.1", "10.0.0.2", "10.0.3.1"}},
+		// a tag spans groups
+		{target: "eu", want: []string{"10.0.0.1", "10.0.1.1"}},
+		{target: "db1", want: []string{"10.0.1.1"}},
+		{target: "nope", want: nil},
+		{target: "web/nope", want: nil},
+		{target: "", want: nil},
+	}
+	for _, tt := range tests {
+		var got []string
+		for _, node := range FindTargets(fleetInventory(), tt.target) {
+			got = append(got, node.Host)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("FindTargets(%q) = %v, want %v", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestFindTargetsOnce(t *testing.T) {
+	node := &Node{Name: "api", Alias: "api", Host: "10.0.4.1", Tags: []string{"api"}}
+	nodes := []*Node{{Name: "api", Children: []*Node{node}}}
+	got := FindTargets(nodes, "api")
+	if len(got) != 1 || got[0] != node {
+		t.Errorf("FindTargets = %v, want the node once", got)
+	}
+}
+
+func TestFleetExitCode(t *testing.T) {
+	tests := []struct {
+		statuses []int
+		want     int
+	}{
+		{statuses: nil, want: 0},
+		{statuses: []int{0, 0}, want: 0},
+		{statuses: []int{0, 1, 0}, want: 1},
+		{statuses: []int{2, 0, 1}, want: 2},
+		{statuses: []int{1, execFailed, 3}, want: execFailed},
+	}
+	for _, tt := range tests {
+		var results []FleetResult
+		for _, s := range tt.statuses {
+			results = append(results, FleetResult{Node: &Node{}, Status: s})
+		}
+		if got := FleetExitCode(results); got != tt.want {
+			t.Errorf("FleetExitCode(%v) = %d, want %d", tt.statuses, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{name: "line", writes: []string{"hello\n"}, want: "w1 | hello\n"},
+		{name: "partial", writes: []string{"hel", "lo\nwor", "ld\n"}, want: "w1 | hello\nw1 | world\n"},
+		{name: "multi-line", writes: []string{"a\nb\n\nc\n"}, want: "w1 | a\nw1 | b\nw1 | \nw1 | c\n"},
+		{name: "unterminated", writes: []string{"a\nb"}, want: "w1 | a\nw1 | b\n"},
+		{name: "empty", writes: nil, want: ""},
+	}
+	for _, tt := range tests {
+		var out bytes.Buffer
+		p := &prefixWriter{mu: &sync.Mutex{}, w: &out, prefix: "w1 | "}
+		for _, w := range tt.writes {
+			if n, err := p.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%s: Write(%q) = %d, %v", tt.name, w, n, err)
+			}
+		}
+		p.Flush()
+		if got := out.String(); got != tt.want {
+			t.Errorf("%s: output %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPrefixWritersDoNotInterleave(t *testing.T) {
+	var mu sync.Mutex
+	var out bytes.Buffer
+	var wg sync.WaitGroup
+	for _, prefix := range []string{"a | ", "b | "} {
+		p := &prefixWriter{mu: &mu, w: &out, prefix: prefix}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				p.Write([]byte("0123"))
+				p.Write([]byte("4567\n"))
+			}
+		}()
+	}
+	wg.Wait()
+	for _, line := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\n") {
+		if line != "a | 01234567" && line != "b | 01234567" {
+			t.Fatalf("interleaved line %q", line)
+		}
+	}
+}
+
+func TestExecFleetUnreachable(t *testing.T) {
+	nodes := []*Node{
+		{Name: "one", Host: "127.0.0.1", Port: 1},
+		{Name: "two", Host: "127.0.0.1", Port: 1},
+	}
+	results := ExecFleet(nodes, "true", 2, true)
+	if len(results) != len(nodes) {
+		t.Fatalf("got %d results, want %d", len(results), len(nodes))
+	}
+	for i, r := range results {
+		if r.Node != nodes[i] {
+			t.Errorf("result %d is for %s, want %s", i, r.Node.label(), nodes[i].label())
+		}
+		if r.Status != execFailed || r.Err == nil {
+			t.Errorf("%s: status %d, error %v, want %d and an error", r.Node.label(), r.Status, r.Err, execFailed)
+		}
+	}
+	if code := FleetExitCode(results); code != execFailed {
+		t.Errorf("FleetExitCode = %d, want %d", code, execFailed)
+	}
+}
@@ -0,0 +1,446 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// Forward declares a port forward on a node. For local forwards (-L) the
+// Local address is listened on and the server dials Remote; for remote
+// forwards (-R) the server listens on Remote and Local is dialed here.
+type Forward struct {
+	Name   string `yaml:"name"`
+	Local  string `yaml:"local"`
+	Remote string `yaml:"remote"`
+}
+
+// describe names the forward for logs, in the direction data is dialed.
+func (f *Forward) describe(kind string) string {
+	if f.Name != "" {
+		return f.Name
+	}
+	if kind == "R" {
+		return fmt.Sprintf("%s -> %s", f.Remote, f.Local)
+	}
+	return fmt.Sprintf("%s -> %s", f.Local, f.Remote)
+}
+
+// listenAddr completes a forward address: a bare port listens on loopback
+// and "*" means every interface.
+func listenAddr(addr string) string {
+	if !strings.Contains(addr, ":") {
+		return net.JoinHostPort("localhost", addr)
+	}
+	if strings.HasPrefix(addr, "*:") {
+		return addr[1:]
+	}
+	return addr
+}
+
+// remoteListenAddr completes the bind address of a remote forward. The
+// server needs an IP, so a bare port binds loopback and "*" every interface.
+func remoteListenAddr(addr string) string {
+	if !strings.Contains(addr, ":") {
+		return net.JoinHostPort("127.0.0.1", addr)
+	}
+	if strings.HasPrefix(addr, "*:") || strings.HasPrefix(addr, ":") {
+		return "0.0.0.0" + addr[strings.Index(addr, ":"):]
+	}
+	return addr
+}
+
+// forwarder runs the port forwards of a node over an ssh client. Local and
+// dynamic listeners outlive the client, so a dropped connection can be
+// replaced with attach without losing listeners or counters.
+type forwarder struct {
+	mu      sync.Mutex
+	client  *ssh.Client
+	logf    func(format string, args ...interface{})
+	tunnels []*tunnel
+}
+
+// startForwards opens every forward declared on node. Forwards that cannot
+// be opened are logged and skipped, so one busy port does not prevent the
+// session from starting.
+func startForwards(client *ssh.Client, node *Node, logf func(format string, args ...interface{})) *forwarder {
+	f := newForwarder(node, logf)
+	f.attach(client)
+	return f
+}
+
+// newForwarder prepares the forwards of node without opening them.
+func newForwarder(node *Node, logf func(format string, args ...interface{})) *forwarder {
+	f := &forwarder{
+		logf: logf,
+	}
+
+	for _, fw := range node.Forwards {
+		fw := fw
+		addr := listenAddr(fw.Local)
+		f.add(&tunnel{
+			kind: "L",
+			name: fw.describe("L"),
+			addr: addr,
+			listen: func() (net.Listener, error) {
+				return net.Listen("tcp", addr)
+			},
+			dial: func(net.Conn) (net.Conn, error) {
+				return f.dial("tcp", fw.Remote)
+			},
+		})
+	}
+
+	for _, fw := range node.RemoteForwards {
+		fw := fw
+		addr := remoteListenAddr(fw.Remote)
+		f.add(&tunnel{
+			kind: "R",
+			name: fw.describe("R"),
+			addr: addr,
+			listen: func() (net.Listener, error) {
+				client := f.current()
+				if client == nil {
+					return nil, errNotConnected
+				}
+				ln, err := client.Listen("tcp", addr)
+				if err != nil {
+					return nil, fmt.Errorf("server refused, check AllowTcpForwarding/GatewayPorts: %w", err)
+				}
+				return ln, nil
+			},
+			dial: func(net.Conn) (net.Conn, error) {
+				return net.Dial("tcp", listenAddr(fw.Local))
+			},
+		})
+	}
+
+	for _, d := range node.DynamicForwards {
+		d := d
+		addr := listenAddr(d.Listen)
+		f.add(&tunnel{
+			kind: "D",
+			name: d.describe(),
+			addr: addr,
+			listen: func() (net.Listener, error) {
+				return net.Listen("tcp", addr)
+			},
+			dial: func(conn net.Conn) (net.Conn, error) {
+				return socksDial(conn, d, f.dial)
+			},
+		})
+	}
+
+	return f
+}
+
+var (
+	errNotConnected = errors.New("not connected")
+	errTunnelClosed = errors.New("forward closed")
+)
+
+func (f *forwarder) add(t *tunnel) {
+	t.logf = f.logf
+	t.conns = make(map[net.Conn]struct{})
+	t.done = make(chan struct{})
+	f.tunnels = append(f.tunnels, t)
+}
+
+// attach makes client carry the forwarded connections. Remote forwards are
+// requested again on the new client; local listeners that failed to open
+// earlier are retried.
+func (f *forwarder) attach(client *ssh.Client) {
+	f.mu.Lock()
+	f.client = client
+	f.mu.Unlock()
+
+	for _, t := range f.tunnels {
+		if t.kind == "R" || !t.listening() {
+			t.open()
+		}
+	}
+}
+
+// detach stops using the current client, e.g. after it disconnected.
+func (f *forwarder) detach() {
+	f.mu.Lock()
+	f.client = nil
+	f.mu.Unlock()
+}
+
+func (f *forwarder) current() *ssh.Client {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.client
+}
+
+func (f *forwarder) dial(network, addr string) (net.Conn, error) {
+	client := f.current()
+	if client == nil {
+		return nil, errNotConnected
+	}
+	return client.Dial(network, addr)
+}
+
+// Close stops every listener and drops the connections still open.
+func (f *forwarder) Close() {
+	for _, t := range f.tunnels {
+		t.close()
+	}
+}
+
+// tunnelStat is a snapshot of one forward's state and counters.
+type tunnelStat struct {
+	Kind     string `json:"kind"`
+	Name     string `json:"name"`
+	Listen   string `json:"listen"`
+	Active   int64  `json:"active"`
+	Total    int64  `json:"total"`
+	BytesIn  int64  `json:"bytes_in"`
+	BytesOut int64  `json:"bytes_out"`
+	Error    string `json:"error,omitempty"`
+}
+
+func (f *forwarder) stats() []tunnelStat {
+	stats := make([]tunnelStat, 0, len(f.tunnels))
+	for _, t := range f.tunnels {
+		stats = append(stats, t.stat())
+	}
+	return stats
+}
+
+// tunnel is one listening forward together with its live connections.
+type tunnel struct {
+	kind string
+	name string
+	addr string
+	// listen opens the listener, dial the upstream side of a connection
+	listen func() (net.Listener, error)
+	dial   func(net.Conn) (net.Conn, error)
+	logf   func(format string, args ...interface{})
+
+	mu       sync.Mutex
+	listener net.Listener
+	lastErr  error
+	conns    map[net.Conn]struct{}
+	closed   bool
+	done     chan struct{} // closed by close
+	wg       sync.WaitGroup
+
+	active   atomic.Int64
+	total    atomic.Int64
+	bytesIn  atomic.Int64
+	bytesOut atomic.Int64
+}
+
+// open (re)opens the listener of t, replacing a previous one.
+func (t *tunnel) open() {
+	ln, err := t.listen()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.listener != nil {
+		t.listener.Close()
+		t.listener = nil
+	}
+	if err != nil {
+		t.lastErr = err
+		t.logf("forward %s %s: listen %s error: %v", t.kind, t.name, t.addr, err)
+		return
+	}
+	if t.closed {
+		ln.Close()
+		return
+	}
+	t.listener = ln
+	t.lastErr = nil
+	t.logf("forward %s %s: listening on %s", t.kind, t.name, ln.Addr())
+	go t.serve(ln)
+}
+
+func (t *tunnel) listening() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.listener != nil
+}
+
+func (t *tunnel) stat() tunnelStat {
+	t.mu.Lock()
+	st := tunnelStat{
+		Kind:   t.kind,
+		Name:   t.name,
+		Listen: t.addr,
+	}
+	if t.listener != nil {
+		st.Listen = t.listener.Addr().String()
+	}
+	if t.lastErr != nil {
+		st.Error = t.lastErr.Error()
+	}
+	t.mu.Unlock()
+
+	st.Active = t.active.Load()
+	st.Total = t.total.Load()
+	st.BytesIn = t.bytesIn.Load()
+	st.BytesOut = t.bytesOut.Load()
+	return st
+}
+
+func (t *tunnel) serve(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		if !t.track(conn) {
+			conn.Close()
+			return
+		}
+		go t.handle(conn)
+	}
+}
+
+func (t *tunnel) handle(conn net.Conn) {
+	defer t.wg.Done()
+	defer t.untrack(conn)
+
+	t.total.Add(1)
+	t.active.Add(1)
+	defer t.active.Add(-1)
+
+	upstream, err := t.dialUpstream(conn)
+	if err == errTunnelClosed {
+		conn.Close()
+		return
+	}
+	if err != nil {
+		t.mu.Lock()
+		t.lastErr = err
+		t.mu.Unlock()
+		t.logf("forward %s %s: connection from %s failed: %v", t.kind, t.name, conn.RemoteAddr(), err)
+		conn.Close()
+		return
+	}
+	t.mu.Lock()
+	t.lastErr = nil
+	t.mu.Unlock()
+	if !t.track(upstream) {
+		upstream.Close()
+		conn.Close()
+		return
+	}
+	defer t.wg.Done()
+	defer t.untrack(upstream)
+
+	t.logf("forward %s %s: connection from %s", t.kind, t.name, conn.RemoteAddr())
+	out, in := pipe(conn, upstream, &t.bytesOut, &t.bytesIn)
+	t.logf("forward %s %s: connection from %s closed (sent %d bytes, received %d bytes)", t.kind, t.name, conn.RemoteAddr(), out, in)
+}
+
+// dialUpstream runs t.dial for conn but gives up once the tunnel is closed,
+// so that close does not wait for the server to answer a channel open that
+// may take as long as its own connect timeout. An upstream connection
+// arriving after that is closed.
+func (t *tunnel) dialUpstream(conn net.Conn) (net.Conn, error) {
+	type result struct {
+		conn net.Conn
+		err  error
+	}
+	dialed := make(chan result, 1)
+	go func() {
+		c, err := t.dial(conn)
+		dialed <- result{c, err}
+	}()
+	select {
+	case r := <-dialed:
+		return r.conn, r.err
+	case <-t.done:
+		go func() {
+			if r := <-dialed; r.conn != nil {
+				r.conn.Close()
+			}
+		}()
+		return nil, errTunnelClosed
+	}
+}
+
+// track registers conn so that close can drop it; it reports false once
+// the tunnel is closed.
+func (t *tunnel) track(conn net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[conn] = struct{}{}
+	t.wg.Add(1)
+	return true
+}
+
+func (t *tunnel) untrack(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+	conn.Close()
+}
+
+func (t *tunnel) close() {
+	t.mu.Lock()
+	if !t.closed {
+		t.closed = true
+		close(t.done)
+	}
+	if t.listener != nil {
+		t.listener.Close()
+	}
+	for conn := range t.conns {
+		conn.Close()
+	}
+	t.mu.Unlock()
+	t.wg.Wait()
+}
+
+// pipe copies between a and b until both directions are done, propagating
+// half-closes where supported. Bytes read from a are added to fromA and
+// bytes read from b to fromB as they flow; the per-call totals are returned.
+func pipe(a, b io.ReadWriteCloser, fromA, fromB *atomic.Int64) (nA, nB int64) {
+	type closeWriter interface {
+		CloseWrite() error
+	}
+	halfClose := func(c io.ReadWriteCloser) {
+		if cw, ok := c.(closeWriter); ok {
+			cw.CloseWrite()
+		} else {
+			c.Close()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		nA, _ = io.Copy(&countingWriter{w: b, n: fromA}, a)
+		halfClose(b)
+		close(done)
+	}()
+	nB, _ = io.Copy(&countingWriter{w: a, n: fromB}, b)
+	halfClose(a)
+	<-done
+	return nA, nB
+}
+
+// countingWriter adds the number of bytes written through it to n.
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n.Add(int64(n))
+	return n, err
+}
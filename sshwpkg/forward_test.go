@@ -0,0 +1,68 @@
+package sshw
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTunnelCloseDoesNotWaitForDial(t *testing.T) {
+	f := &forwarder{logf: func(string, ...interface{}) {}}
+	dialing := make(chan struct{})
+	release := make(chan struct{})
+	late, lateRemote := net.Pipe()
+	defer lateRemote.Close()
+	f.add(&tunnel{
+		kind: "L",
+		name: "blocking",
+		addr: "127.0.0.1:0",
+		listen: func() (net.Listener, error) {
+			return net.Listen("tcp", "127.0.0.1:0")
+		},
+		// stands in for a channel open the server does not answer
+		dial: func(net.Conn) (net.Conn, error) {
+			close(dialing)
+			<-release
+			return late, nil
+		},
+	})
+	tun := f.tunnels[0]
+	tun.open()
+	if !tun.listening() {
+		t.Fatalf("listen error: %v", tun.stat().Error)
+	}
+
+	conn, err := net.Dial("tcp", tun.stat().Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	select {
+	case <-dialing:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the forward never dialed")
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		f.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close waited for the pending dial")
+	}
+
+	// the upstream connection that arrives after Close is dropped
+	close(release)
+	lateRemote.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := lateRemote.Read(make([]byte, 1)); err == nil {
+		t.Error("late upstream connection was not closed")
+	}
+	if err := conn.SetReadDeadline(time.Now().Add(5 * time.Second)); err == nil {
+		if _, err := conn.Read(make([]byte, 1)); err == nil {
+			t.Error("client connection was not closed")
+		}
+	}
+}
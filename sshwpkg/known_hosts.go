@@ -0,0 +1,165 @@
+package sshw
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/atrox/homedir"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// Host key policies accepted by the host-key-policy node field.
+const (
+	// HostKeyPolicyStrict refuses hosts whose key is not already known.
+	HostKeyPolicyStrict = "strict"
+	// HostKeyPolicyAsk prompts on first contact and remembers accepted keys.
+	HostKeyPolicyAsk = "ask"
+	// HostKeyPolicyInsecure skips host key verification entirely.
+	HostKeyPolicyInsecure = "insecure"
+)
+
+// knownHostsFiles returns the known_hosts files consulted when verifying
+// a host key. Accepted keys are appended to the last one.
+func knownHostsFiles() []string {
+	var files []string
+	if p, err := homedir.Expand("~/.ssh/known_hosts"); err == nil {
+		files = append(files, p)
+	}
+	if p, err := stateDir("known_hosts"); err == nil {
+		files = append(files, p)
+	}
+	return files
+}
+
+// loadKnownHosts builds a knownhosts callback from the files that exist.
+func loadKnownHosts() (ssh.HostKeyCallback, error) {
+	var files []string
+	for _, f := range knownHostsFiles() {
+		if _, err := os.Stat(f); err == nil {
+			files = append(files, f)
+		}
+	}
+	return knownhosts.New(files...)
+}
+
+// hostKeyCallback returns the host key verification used for node.
+func hostKeyCallback(node *Node) ssh.HostKeyCallback {
+	policy := node.hostKeyPolicy()
+	if policy == HostKeyPolicyInsecure {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := checkKnownHost(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		fingerprint := ssh.FingerprintSHA256(key)
+		if policy == HostKeyPolicyStrict {
+			return fmt.Errorf("host key for %s is unknown (%s %s) and host-key-policy is strict", hostname, key.Type(), fingerprint)
+		}
+
+		promptMu.Lock()
+		defer promptMu.Unlock()
+
+		// another connection may have accepted the key while we waited
+		err = checkKnownHost(hostname, remote, key)
+		if !errors.As(err, &keyErr) || len(keyErr.Want) > 0 {
+			return err
+		}
+
+		fmt.Printf("The authenticity of host '%s' can't be established.\n", hostname)
+		fmt.Printf("%s key fingerprint is %s.\n", strings.ToUpper(strings.TrimPrefix(key.Type(), "ssh-")), fingerprint)
+		if !confirm("Are you sure you want to continue connecting (yes/no)? ") {
+			return fmt.Errorf("host key verification failed for %s: key not accepted", hostname)
+		}
+		if err := addKnownHost(hostname, remote, key); err != nil {
+			l.Errorf("save host key for %s error: %v", hostname, err)
+		}
+		return nil
+	}
+}
+
+// checkKnownHost verifies key against the known_hosts files and turns a
+// mismatch into an error that names the offending entry.
+func checkKnownHost(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	cb, err := loadKnownHosts()
+	if err != nil {
+		return fmt.Errorf("load known hosts error: %w", err)
+	}
+	err = cb(hostname, remote, key)
+
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) && len(keyErr.Want) > 0 {
+		want := keyErr.Want[0]
+		return fmt.Errorf("REMOTE HOST IDENTIFICATION HAS CHANGED for %s: server sent %s %s, but %s:%d expects %s %s; refusing to connect (%w)",
+			hostname, key.Type(), ssh.FingerprintSHA256(key),
+			want.Filename, want.Line, want.Key.Type(), ssh.FingerprintSHA256(want.Key), err)
+	}
+	return err
+}
+
+// addKnownHost appends key to the sshw known_hosts file.
+func addKnownHost(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	files := knownHostsFiles()
+	path := files[len(files)-1]
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	addresses := []string{hostname}
+	if tcp, ok := remote.(*net.TCPAddr); ok && tcp.IP != nil && knownhosts.Normalize(tcp.String()) != knownhosts.Normalize(hostname) {
+		addresses = append(addresses, tcp.String())
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line(addresses, key))
+	return err
+}
+
+// knownHostKeyAlgorithms returns the host key algorithms matching the keys
+// already recorded for address, so that the server is asked for a key type
+// we can actually verify. It returns nil when the host is unknown.
+func knownHostKeyAlgorithms(address string) []string {
+	cb, err := loadKnownHosts()
+	if err != nil {
+		return nil
+	}
+
+	// probe with a key that never matches to learn which keys are recorded
+	probe, err := ssh.NewPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)))
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(cb(address, &net.TCPAddr{}, probe), &keyErr) {
+		return nil
+	}
+
+	var algos []string
+	seen := make(map[string]bool)
+	for _, want := range keyErr.Want {
+		typ := want.Key.Type()
+		if seen[typ] {
+			continue
+		}
+		seen[typ] = true
+		if typ == ssh.KeyAlgoRSA {
+			algos = append(algos, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algos = append(algos, typ)
+	}
+	return algos
+}
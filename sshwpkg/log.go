@@ -2,6 +2,7 @@ package sshw
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 )
@@ -28,6 +29,12 @@ func SetLogger(logger Logger) {
 	l = logger
 }
 
+// SetLogOutput sets where the default logger writes, standard output
+// unless changed.
+func SetLogOutput(w io.Writer) {
+	stdlog.SetOutput(w)
+}
+
 func (l *logger) Info(args ...interface{}) {
 	l.println("[info]", args...)
 }
@@ -51,3 +58,9 @@ func (l *logger) println(level string, args ...interface{}) {
 func (l *logger) printlnf(level string, format string, args ...interface{}) {
 	stdlog.Println(level, fmt.Sprintf(format, args...))
 }
+
+// rawInfof logs while the terminal is in raw mode, where a bare "\n" does
+// not return the cursor to the first column.
+func rawInfof(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "\r%s[info] %s\r\n", stdlog.Prefix(), fmt.Sprintf(format, args...))
+}
@@ -0,0 +1,16 @@
+//go:build windows
+
+package sshw
+
+import "os"
+
+// processAlive reports whether a process with the given pid exists; on
+// Windows FindProcess fails for processes that have exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
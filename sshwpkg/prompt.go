@@ -0,0 +1,112 @@
+package sshw
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh/terminal"
+)
+
+// promptMu serializes interactive prompts so that connections dialed
+// concurrently never interleave their questions on the terminal.
+var promptMu sync.Mutex
+
+// stdin buffers the answers typed to line prompts. It is shared by every
+// prompt so that input typed ahead is not lost in the buffer of an earlier
+// one.
+var stdin = bufio.NewReader(os.Stdin)
+
+// readLine reads one line from stdin without its line ending.
+func readLine() (string, error) {
+	line, err := stdin.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// confirm asks a yes/no question on the terminal and reports whether the
+// user answered yes. It always answers no when stdin is not a terminal.
+// Callers are expected to hold promptMu.
+func confirm(question string) bool {
+	if !terminal.IsTerminal(int(os.Stdin.Fd())) {
+		return false
+	}
+
+	for {
+		fmt.Print(question)
+		answer, err := readLine()
+		if err != nil {
+			fmt.Println()
+			return false
+		}
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "yes", "y":
+			return true
+		case "no", "n":
+			return false
+		}
+		fmt.Println("Please type 'yes' or 'no'.")
+	}
+}
+
+// rawPrompt wraps the stdin pipe of an interactive session so that a yes/no
+// question can be asked while the terminal is in raw mode: the keystroke
+// following the question answers it instead of reaching the remote side.
+type rawPrompt struct {
+	io.WriteCloser
+	mu      sync.Mutex
+	pending chan byte
+}
+
+func (p *rawPrompt) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	answer := p.pending
+	p.pending = nil
+	p.mu.Unlock()
+
+	if answer == nil || len(b) == 0 {
+		return p.WriteCloser.Write(b)
+	}
+	answer <- b[0]
+	if len(b) == 1 {
+		return 1, nil
+	}
+	n, err := p.WriteCloser.Write(b[1:])
+	return n + 1, err
+}
+
+// ask prints question and waits for a single keystroke, treating anything
+// but y/Y (or no answer within a minute) as a refusal.
+func (p *rawPrompt) ask(question string) bool {
+	promptMu.Lock()
+	defer promptMu.Unlock()
+
+	answer := make(chan byte, 1)
+	p.mu.Lock()
+	p.pending = answer
+	p.mu.Unlock()
+
+	fmt.Fprintf(os.Stderr, "\r\n[sshw] %s [y/N] ", question)
+	var b byte
+	select {
+	case b = <-answer:
+	case <-time.After(time.Minute):
+		p.mu.Lock()
+		p.pending = nil
+		p.mu.Unlock()
+	}
+
+	ok := b == 'y' || b == 'Y'
+	if ok {
+		fmt.Fprint(os.Stderr, "yes\r\n")
+	} else {
+		fmt.Fprint(os.Stderr, "no\r\n")
+	}
+	return ok
+}
@@ -0,0 +1,146 @@
+package sshw
+
+import (
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// expandProxyCommand replaces the OpenSSH tokens %h (host), %p (port),
+// %r (user), %n (alias as typed, falling back to host) and %%.
+func expandProxyCommand(command string, node *Node) string {
+	name := node.alias()
+	if name == "" {
+		name = node.Host
+	}
+	r := strings.NewReplacer(
+		"%%", "%",
+		"%h", node.Host,
+		"%p", strconv.Itoa(node.port()),
+		"%r", node.user(),
+		"%n", name,
+	)
+	return r.Replace(command)
+}
+
+// proxyCommandConn starts the node's proxy-command and returns a net.Conn
+// backed by its stdin and stdout, ready to be handed to ssh.NewClientConn.
+func proxyCommandConn(node *Node) (net.Conn, error) {
+	cmd := shellCommand(expandProxyCommand(node.ProxyCommand, node))
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{
+		cmd:    cmd,
+		stdin:  stdin,
+		stdout: stdout,
+		addr:   proxyAddr(node.addr()),
+	}, nil
+}
+
+// proxyConn adapts a running proxy command to net.Conn. Pipes cannot time
+// out a single read or write, so an expired deadline kills the command,
+// which ends the connection with os.ErrDeadlineExceeded.
+type proxyConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   proxyAddr
+	once   sync.Once
+
+	mu      sync.Mutex
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func (c *proxyConn) Read(b []byte) (int, error) {
+	n, err := c.stdout.Read(b)
+	if err != nil && c.expired.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *proxyConn) Write(b []byte) (int, error) {
+	n, err := c.stdin.Write(b)
+	if err != nil && c.expired.Load() {
+		err = os.ErrDeadlineExceeded
+	}
+	return n, err
+}
+
+func (c *proxyConn) Close() error {
+	c.once.Do(func() {
+		c.stdin.Close()
+		if c.cmd.Process != nil {
+			c.cmd.Process.Kill()
+		}
+		c.cmd.Wait()
+	})
+	return nil
+}
+
+func (c *proxyConn) LocalAddr() net.Addr {
+	return proxyAddr("proxy-command")
+}
+
+func (c *proxyConn) RemoteAddr() net.Addr {
+	return c.addr
+}
+
+// SetDeadline kills the proxy command at t; the zero time cancels it.
+func (c *proxyConn) SetDeadline(t time.Time) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.expired.Load() {
+		return os.ErrDeadlineExceeded
+	}
+	if c.timer != nil {
+		c.timer.Stop()
+		c.timer = nil
+	}
+	if !t.IsZero() {
+		c.timer = time.AfterFunc(time.Until(t), func() {
+			c.expired.Store(true)
+			c.Close()
+		})
+	}
+	return nil
+}
+
+// SetReadDeadline is SetDeadline: reads and writes share one deadline.
+func (c *proxyConn) SetReadDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// SetWriteDeadline is SetDeadline: reads and writes share one deadline.
+func (c *proxyConn) SetWriteDeadline(t time.Time) error {
+	return c.SetDeadline(t)
+}
+
+// proxyAddr is the host:port a proxy command connects to.
+type proxyAddr string
+
+func (a proxyAddr) Network() string {
+	return "proxy-command"
+}
+
+func (a proxyAddr) String() string {
+	return string(a)
+}
@@ -0,0 +1,53 @@
+package sshw
+
+import (
+	"errors"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExpandProxyCommand(t *testing.T) {
+	node := &Node{Host: "10.0.0.5", Port: 2222, User: "deploy", Alias: "web"}
+	tests := []struct {
+		command string
+		node    *Node
+		want    string
+	}{
+		{command: "ssh -W %h:%p bastion", node: node, want: "ssh -W 10.0.0.5:2222 bastion"},
+		{command: "connect %r@%h", node: node, want: "connect deploy@10.0.0.5"},
+		{command: "proxy %n", node: node, want: "proxy web"},
+		{command: "proxy %n", node: &Node{Host: "db"}, want: "proxy db"},
+		{command: "nc %h %p", node: &Node{Host: "db"}, want: "nc db 22"},
+		{command: "login %r", node: &Node{Host: "db"}, want: "login root"},
+		{command: "echo 100%% %%h %h", node: node, want: "echo 100% %h 10.0.0.5"},
+		{command: "echo %x %", node: node, want: "echo %x %"},
+	}
+	for _, tt := range tests {
+		if got := expandProxyCommand(tt.command, tt.node); got != tt.want {
+			t.Errorf("expandProxyCommand(%q) = %q, want %q", tt.command, got, tt.want)
+		}
+	}
+}
+
+func TestProxyConnDeadline(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs a POSIX shell")
+	}
+	conn, err := proxyCommandConn(&Node{Host: "example.org", ProxyCommand: "sleep 60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	conn.SetDeadline(time.Now().Add(100 * time.Millisecond))
+	start := time.Now()
+	_, err = conn.Read(make([]byte, 1))
+	if !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("Read error = %v, want %v", err, os.ErrDeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("Read returned after %s", elapsed)
+	}
+}
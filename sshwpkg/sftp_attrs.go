@@ -0,0 +1,335 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+)
+
+// modeFunc computes the new permission bits (including setuid, setgid and
+// sticky, as in chmod(2)) of a file from its current ones.
+type modeFunc func(perm uint32, isDir bool) uint32
+
+// parseMode parses an octal mode such as 0755 or a symbolic one such as
+// u+x,go-w or a=rX like chmod(1). Without a who letter a symbolic clause
+// applies to everyone; the umask is not taken into account.
+func parseMode(spec string) (modeFunc, error) {
+	if spec != "" && strings.Trim(spec, "01234567") == "" {
+		perm, err := strconv.ParseUint(spec, 8, 32)
+		if err != nil || perm > 07777 {
+			return nil, fmt.Errorf("invalid mode %s", spec)
+		}
+		return func(uint32, bool) uint32 { return uint32(perm) }, nil
+	}
+
+	type change struct {
+		who   uint32
+		op    byte
+		perms string
+	}
+	var changes []change
+	for _, clause := range strings.Split(spec, ",") {
+		i := 0
+		var who uint32
+		for ; i < len(clause) && strings.IndexByte("ugoa", clause[i]) >= 0; i++ {
+			switch clause[i] {
+			case 'u':
+				who |= 04700
+			case 'g':
+				who |= 02070
+			case 'o':
+				who |= 01007
+			case 'a':
+				who |= 07777
+			}
+		}
+		if who == 0 {
+			who = 07777
+		}
+		if i == len(clause) {
+			return nil, fmt.Errorf("invalid mode %s", spec)
+		}
+		for i < len(clause) {
+			op := clause[i]
+			if op != '+' && op != '-' && op != '=' {
+				return nil, fmt.Errorf("invalid mode %s", spec)
+			}
+			i++
+			j := i
+			for ; j < len(clause) && strings.IndexByte("rwxXst", clause[j]) >= 0; j++ {
+			}
+			changes = append(changes, change{who: who, op: op, perms: clause[i:j]})
+			i = j
+		}
+	}
+
+	return func(perm uint32, isDir bool) uint32 {
+		for _, c := range changes {
+			var bits uint32
+			for _, p := range c.perms {
+				switch p {
+				case 'r':
+					bits |= 0444
+				case 'w':
+					bits |= 0222
+				case 'x':
+					bits |= 0111
+				case 'X':
+					if isDir || perm&0111 != 0 {
+						bits |= 0111
+					}
+				case 's':
+					bits |= 06000
+				case 't':
+					bits |= 01000
+				}
+			}
+			bits &= c.who
+			switch c.op {
+			case '+':
+				perm |= bits
+			case '-':
+				perm &^= bits
+			case '=':
+				perm = perm&^c.who | bits
+			}
+		}
+		return perm
+	}, nil
+}
+
+// fileStat returns the raw SFTP attributes of info.
+func fileStat(info os.FileInfo) (*sftp.FileStat, error) {
+	st, ok := info.Sys().(*sftp.FileStat)
+	if !ok {
+		return nil, errors.New("the server returned no attributes")
+	}
+	return st, nil
+}
+
+// changeModes changes the mode of every path: chmod [-R] <mode> <path>...
+func (s *SFTPShell) changeModes(args []word) {
+	flags, args, err := splitFlags(args, "R")
+	if err != nil || len(args) < 2 {
+		fmt.Println("Usage: chmod [-R] <mode> <path>...")
+		return
+	}
+	mode, err := parseMode(args[0].text)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
+	s.forEachPath(args[1:], flags['R'], func(p string, info os.FileInfo) error {
+		st, err := fileStat(info)
+		if err != nil {
+			return err
+		}
+		return s.client.Chmod(p, os.FileMode(mode(st.Mode&07777, info.IsDir())))
+	})
+}
+
+// changeOwners changes the owner, and the group when given, of every path:
+// chown [-R] <uid>[:<gid>] <path>...
+func (s *SFTPShell) changeOwners(args []word) {
+	flags, args, err := splitFlags(args, "R")
+	if err != nil || len(args) < 2 {
+		fmt.Println("Usage: chown [-R] <uid>[:<gid>] <path>...")
+		return
+	}
+	owner, group, hasGroup := strings.Cut(args[0].text, ":")
+	uid, err := parseID(owner)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	gid := -1
+	if hasGroup {
+		if gid, err = parseID(group); err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+	}
+	s.chown(args[1:], flags['R'], uid, gid)
+}
+
+// changeGroups changes the group of every path: chgrp [-R] <gid> <path>...
+func (s *SFTPShell) changeGroups(args []word) {
+	flags, args, err := splitFlags(args, "R")
+	if err != nil || len(args) < 2 {
+		fmt.Println("Usage: chgrp [-R] <gid> <path>...")
+		return
+	}
+	gid, err := parseID(args[0].text)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	s.chown(args[1:], flags['R'], -1, gid)
+}
+
+// chown sets the owner and group of every path; -1 keeps the current one.
+func (s *SFTPShell) chown(paths []word, recursive bool, uid, gid int) {
+	s.forEachPath(paths, recursive, func(p string, info os.FileInfo) error {
+		st, err := fileStat(info)
+		if err != nil {
+			return err
+		}
+		u, g := uid, gid
+		if u < 0 {
+			u = int(st.UID)
+		}
+		if g < 0 {
+			g = int(st.GID)
+		}
+		return s.client.Chown(p, u, g)
+	})
+}
+
+// parseID parses a numeric user or group ID. SFTP version 3 transfers only
+// IDs, so names cannot be resolved on the server.
+func parseID(s string) (int, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a numeric ID (SFTP has no user or group names)", s)
+	}
+	return int(id), nil
+}
+
+// forEachPath calls fn for every path after glob expansion, and with
+// recursive for everything below directories too. Symbolic links found
+// while recursing are skipped like chmod -R does, since changing them would
+// change their targets.
+func (s *SFTPShell) forEachPath(args []word, recursive bool, fn func(p string, info os.FileInfo) error) {
+	var errs []error
+	for _, arg := range args {
+		paths, err := s.expandRemote(arg)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		for _, p := range paths {
+			info, err := s.client.Stat(p)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", p, err))
+				continue
+			}
+			if !recursive || !info.IsDir() {
+				if err := fn(p, info); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", p, err))
+				}
+				continue
+			}
+			walker := s.client.Walk(p)
+			for walker.Step() {
+				if err := walker.Err(); err != nil {
+					errs = append(errs, err)
+					continue
+				}
+				if walker.Stat().Mode()&os.ModeSymlink != 0 {
+					continue
+				}
+				if err := fn(walker.Path(), walker.Stat()); err != nil {
+					errs = append(errs, fmt.Errorf("%s: %w", walker.Path(), err))
+				}
+			}
+		}
+	}
+	printErrors(errs)
+}
+
+// makeLink creates a hard link, or a symbolic one with -s or as symlink:
+// ln [-s] <target> <link>. The target of a symbolic link is stored as
+// given, so a relative target is relative to the directory of the link.
+func (s *SFTPShell) makeLink(cmd string, args []word) {
+	flags, args, err := splitFlags(args, "s")
+	if err != nil || len(args) != 2 {
+		if cmd == "symlink" {
+			fmt.Println("Usage: symlink <target> <link>")
+		} else {
+			fmt.Println("Usage: ln [-s] <target> <link>")
+		}
+		return
+	}
+	target, link := args[0].text, s.resolvePath(args[1].text)
+
+	if cmd == "symlink" || flags['s'] {
+		err = s.client.Symlink(target, link)
+	} else {
+		err = s.client.Link(s.resolvePath(target), link)
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	fmt.Printf("Linked: %s -> %s\n", link, target)
+}
+
+// statRemote prints every attribute of the given paths without following
+// symbolic links.
+func (s *SFTPShell) statRemote(args []word) {
+	if len(args) == 0 {
+		fmt.Println("Usage: stat <path>...")
+		return
+	}
+	for _, arg := range args {
+		paths, err := s.expandRemote(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		for _, p := range paths {
+			s.printStat(p)
+		}
+	}
+}
+
+func (s *SFTPShell) printStat(p string) {
+	info, err := s.client.Lstat(p)
+	if err != nil {
+		fmt.Printf("Error: %s: %v\n", p, err)
+		return
+	}
+	st, err := fileStat(info)
+	if err != nil {
+		fmt.Printf("Error: %s: %v\n", p, err)
+		return
+	}
+
+	name := p
+	if info.Mode()&os.ModeSymlink != 0 {
+		if target, err := s.client.ReadLink(p); err == nil {
+			name += " -> " + target
+		}
+	}
+	fmt.Printf("  File: %s\n", name)
+	fmt.Printf("  Type: %s\n", fileType(info.Mode()))
+	fmt.Printf("  Size: %d\n", st.Size)
+	fmt.Printf("  Mode: %04o (%s)\n", st.Mode&07777, info.Mode())
+	fmt.Printf("   Uid: %d\n", st.UID)
+	fmt.Printf("   Gid: %d\n", st.GID)
+	fmt.Printf("Access: %s\n", time.Unix(int64(st.Atime), 0).Format("2006-01-02 15:04:05 -0700"))
+	fmt.Printf("Modify: %s\n", time.Unix(int64(st.Mtime), 0).Format("2006-01-02 15:04:05 -0700"))
+	for _, ext := range st.Extended {
+		fmt.Printf("  Attr: %s=%s\n", ext.ExtType, ext.ExtData)
+	}
+}
+
+// fileType names the type of a file for stat.
+func fileType(mode os.FileMode) string {
+	switch {
+	case mode.IsDir():
+		return "directory"
+	case mode.IsRegular():
+		return "regular file"
+	case mode&os.ModeSymlink != 0:
+		return "symbolic link"
+	default:
+		return fileKind(mode)
+	}
+}
@@ -0,0 +1,44 @@
+package sshw
+
+import "testing"
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		spec  string
+		perm  uint32
+		isDir bool
+		want  uint32
+	}{
+		{spec: "755", perm: 0600, want: 0755},
+		{spec: "0644", perm: 0777, want: 0644},
+		{spec: "4755", perm: 0600, want: 04755},
+		{spec: "a=rX", perm: 0640, want: 0444},
+		{spec: "a=rX", perm: 0740, want: 0555},
+		{spec: "a=rX", perm: 0700, isDir: true, want: 0555},
+		{spec: "u+s", perm: 0755, want: 04755},
+		{spec: "g+s", perm: 0755, isDir: true, want: 02755},
+		{spec: "o+t", perm: 0777, isDir: true, want: 01777},
+		{spec: "go-w,u+x", perm: 0666, want: 0744},
+		{spec: "+x", perm: 0644, want: 0755},
+		{spec: "u=rw,go=", perm: 0755, want: 0600},
+		{spec: "u-s", perm: 04755, want: 0755},
+	}
+	for _, tt := range tests {
+		mode, err := parseMode(tt.spec)
+		if err != nil {
+			t.Errorf("parseMode(%q) error = %v", tt.spec, err)
+			continue
+		}
+		if got := mode(tt.perm, tt.isDir); got != tt.want {
+			t.Errorf("parseMode(%q) on %04o = %04o, want %04o", tt.spec, tt.perm, got, tt.want)
+		}
+	}
+}
+
+func TestParseModeInvalid(t *testing.T) {
+	for _, spec := range []string{"", "8", "0789", "77777", "u+q", "u", "z+x", "u+x,", "u*x"} {
+		if _, err := parseMode(spec); err == nil {
+			t.Errorf("parseMode(%q) succeeded, want an error", spec)
+		}
+	}
+}
@@ -13,11 +13,15 @@ import (
 	"github.com/schollz/progressbar/v3"
 )
 
-// listRemote lists files in remote directory
-func (s *SFTPShell) listRemote(args []string) {
+// listRemote lists files in remote directory, or the files matching a glob
+func (s *SFTPShell) listRemote(args []word) {
 	path := s.pwd
 	if len(args) > 0 {
-		path = s.resolvePath(args[0])
+		if args[0].glob() {
+			s.listRemoteMatches(args[0])
+			return
+		}
+		path = s.resolvePath(args[0].text)
 	}
 
 	files, err := s.client.ReadDir(path)
@@ -136,18 +140,35 @@ func (s *SFTPShell) changeLocalDir(args []string) {
 	s.localPwd = filepath.Clean(resolvedPath)
 }
 
-// downloadFile downloads file from remote to local
-func (s *SFTPShell) downloadFile(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: get <remote-file> [local-file]")
+// downloadFile downloads file from remote to local; cmd is get or reget
+func (s *SFTPShell) downloadFile(cmd string, args []word) {
+	flags, args, err := splitFlags(args, "racp")
+	if err != nil || len(args) == 0 {
+		fmt.Printf("Usage: %s [-r] [-a] [-c] [-p] <remote-file> [local-file]\n", cmd)
 		return
 	}
+	opts := s.transferOptions(cmd == "reget", flags)
 
-	remotePath := s.resolvePath(args[0])
+	sources, err := s.expandRemote(args[0])
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if len(sources) > 1 {
+		// a pattern matching several files downloads them into a directory
+		dir := s.localPwd
+		if len(args) > 1 {
+			dir = s.resolveLocalPath(args[1].text)
+		}
+		s.getMany(sources, dir, flags['r'], opts)
+		return
+	}
+
+	remotePath := sources[0]
 	localPath := ""
 
 	if len(args) > 1 {
-		localPath = args[1]
+		localPath = args[1].text
 		if !filepath.IsAbs(localPath) {
 			localPath = filepath.Join(s.localPwd, localPath)
 		}
@@ -156,112 +177,121 @@ func (s *SFTPShell) downloadFile(args []string) {
 		localPath = filepath.Join(s.localPwd, filepath.Base(remotePath))
 	}
 
-	// Get file size first
-	srcFile, err := s.client.Open(remotePath)
-	if err != nil {
-		fmt.Printf("Error opening remote file: %v\n", err)
+	if info, err := s.client.Stat(remotePath); err == nil && info.IsDir() {
+		if !flags['r'] {
+			fmt.Printf("Error: %s is a directory (use get -r)\n", remotePath)
+			return
+		}
+		files, bytesWritten, errs := downloadTree(s.client, remotePath, localPath, opts)
+		fmt.Printf("Download complete: %s (%d files, %.2f MB)\n", localPath, files, float64(bytesWritten)/1024/1024)
+		printErrors(errs)
 		return
 	}
-	defer srcFile.Close()
-
-	info, err := s.client.Stat(remotePath)
-	var fileSize int64
-	if err == nil {
-		fileSize = info.Size()
-	}
 
-	// Create local file
-	dstFile, err := os.Create(localPath)
+	bytesWritten, err := download(s.client, remotePath, localPath, opts)
 	if err != nil {
-		fmt.Printf("Error creating local file: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
-	defer dstFile.Close()
 
-	// Wrap dstFile with progress tracking
-	progressDst := &progressWriter{
-		writer:      dstFile,
-		total:       fileSize,
-		description: fmt.Sprintf("Downloading %s", filepath.Base(remotePath)),
+	fmt.Printf("Download complete: %s (%.2f MB)\n", localPath, float64(bytesWritten)/1024/1024)
+}
+
+// uploadFile uploads file from local to remote; cmd is put or reput
+func (s *SFTPShell) uploadFile(cmd string, args []word) {
+	flags, args, err := splitFlags(args, "racp")
+	if err != nil || len(args) == 0 {
+		fmt.Printf("Usage: %s [-r] [-a] [-c] [-p] <local-file> [remote-file]\n", cmd)
+		return
 	}
+	opts := s.transferOptions(cmd == "reput", flags)
 
-	// Use WriteTo for optimized concurrent reads from remote server
-	bytesWritten, err := srcFile.WriteTo(progressDst)
+	sources, err := s.expandLocal(args[0])
 	if err != nil {
-		// Truncate local file to avoid data holes when transfer fails
-		if info, statErr := dstFile.Stat(); statErr == nil {
-			_ = dstFile.Truncate(info.Size())
-		}
-		fmt.Printf("\nError downloading file: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
-
-	fmt.Fprint(os.Stderr, "\n")
-	fmt.Printf("Download complete: %s (%.2f MB)\n", localPath, float64(bytesWritten)/1024/1024)
-}
-
-// uploadFile uploads file from local to remote
-func (s *SFTPShell) uploadFile(args []string) {
-	if len(args) == 0 {
-		fmt.Println("Usage: put <local-file> [remote-file]")
+	if len(sources) > 1 {
+		// a pattern matching several files uploads them into a directory
+		dir := s.pwd
+		if len(args) > 1 {
+			dir = s.resolvePath(args[1].text)
+		}
+		s.putMany(sources, dir, flags['r'], opts)
 		return
 	}
 
-	localPath := args[0]
-	if !filepath.IsAbs(localPath) {
-		localPath = filepath.Join(s.localPwd, localPath)
-	}
+	localPath := sources[0]
 	remotePath := ""
 
 	if len(args) > 1 {
-		remotePath = s.resolvePath(args[1])
+		remotePath = s.resolvePath(args[1].text)
 	} else {
 		remotePath = filepath.Join(s.pwd, filepath.Base(localPath))
 	}
 
-	// Get file size first
-	srcFile, err := os.Open(localPath)
-	if err != nil {
-		fmt.Printf("Error opening local file: %v\n", err)
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		if !flags['r'] {
+			fmt.Printf("Error: %s is a directory (use put -r)\n", localPath)
+			return
+		}
+		files, bytesWritten, errs := uploadTree(s.client, localPath, remotePath, opts)
+		fmt.Printf("Upload complete: %s (%d files, %.2f MB)\n", remotePath, files, float64(bytesWritten)/1024/1024)
+		printErrors(errs)
 		return
 	}
-	defer srcFile.Close()
-
-	info, err := srcFile.Stat()
-	var fileSize int64
-	if err == nil {
-		fileSize = info.Size()
-	}
 
-	// Create remote file
-	dstFile, err := s.client.Create(remotePath)
+	bytesWritten, err := upload(s.client, localPath, remotePath, opts)
 	if err != nil {
-		fmt.Printf("Error creating remote file: %v\n", err)
+		fmt.Printf("Error: %v\n", err)
 		return
 	}
-	defer dstFile.Close()
 
-	// Wrap srcFile with progress tracking
-	// progressReader implements Size() which enables sftp.File.ReadFrom to use concurrent writes
-	progressSrc := &progressReader{
-		reader:      srcFile,
-		total:       fileSize,
-		description: fmt.Sprintf("Uploading %s", filepath.Base(localPath)),
+	fmt.Printf("Upload complete: %s (%.2f MB)\n", remotePath, float64(bytesWritten)/1024/1024)
+}
+
+// transferOptions returns the options of get and put: -a (or reget and
+// reput) resumes partial files, -c checks the part already transferred and
+// -p (or preserve on the node) keeps modes and times.
+func (s *SFTPShell) transferOptions(resume bool, flags map[rune]bool) transferOptions {
+	return transferOptions{
+		resume:   resume || flags['a'],
+		verify:   flags['c'],
+		ssh:      s.ssh,
+		preserve: flags['p'] || s.node.Preserve,
 	}
+}
 
-	// Use ReadFrom for optimized concurrent writes to remote server
-	bytesWritten, err := dstFile.ReadFrom(progressSrc)
-	if err != nil {
-		// Truncate remote file to avoid data holes when concurrent write fails
-		if info, statErr := dstFile.Stat(); statErr == nil {
-			_ = dstFile.Truncate(info.Size())
-		}
-		fmt.Printf("\nError uploading file: %v\n", err)
+// printErrors prints the failures of a recursive transfer
+func printErrors(errs []error) {
+	if len(errs) == 0 {
 		return
 	}
+	fmt.Printf("%d error(s):\n", len(errs))
+	for _, err := range errs {
+		fmt.Printf("  %v\n", err)
+	}
+}
 
-	fmt.Fprint(os.Stderr, "\n")
-	fmt.Printf("Upload complete: %s (%.2f MB)\n", remotePath, float64(bytesWritten)/1024/1024)
+// splitFlags separates leading single letter flags such as -r or -ra from
+// the operands of a command; "--" ends the flags. Letters not in allowed
+// are an error.
+func splitFlags(args []word, allowed string) (map[rune]bool, []word, error) {
+	flags := make(map[rune]bool)
+	for len(args) > 0 && len(args[0].text) > 1 && args[0].text[0] == '-' {
+		arg := args[0].text
+		args = args[1:]
+		if arg == "--" {
+			break
+		}
+		for _, c := range arg[1:] {
+			if !strings.ContainsRune(allowed, c) {
+				return nil, nil, fmt.Errorf("unknown option -%c", c)
+			}
+			flags[c] = true
+		}
+	}
+	return flags, args, nil
 }
 
 // makeRemoteDir creates a remote directory
@@ -302,16 +332,28 @@ func (s *SFTPShell) makeLocalDir(args []string) {
 	fmt.Printf("Directory created: %s\n", path)
 }
 
-// removeRemote removes remote file/directory
-func (s *SFTPShell) removeRemote(args []string) {
+// removeRemote removes remote files/directories, expanding globs
+func (s *SFTPShell) removeRemote(args []word) {
 	if len(args) == 0 {
-		fmt.Println("Usage: rm <path>")
+		fmt.Println("Usage: rm <path>...")
 		return
 	}
 
-	path := s.resolvePath(args[0])
+	for _, arg := range args {
+		paths, err := s.expandRemote(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		for _, path := range paths {
+			s.removeRemotePath(path)
+		}
+	}
+}
 
-	info, err := s.client.Stat(path)
+// removeRemotePath removes one remote file or empty directory
+func (s *SFTPShell) removeRemotePath(path string) {
+	info, err := s.client.Lstat(path)
 	if err != nil {
 		fmt.Printf("Error: %v\n", err)
 		return
@@ -352,16 +394,39 @@ func (s *SFTPShell) removeLocal(args []string) {
 	fmt.Printf("Removed: %s\n", path)
 }
 
-// moveRemote moves/renotes remote file
-func (s *SFTPShell) moveRemote(args []string) {
+// moveRemote moves/renotes remote file; several sources or a glob are moved
+// into the destination directory
+func (s *SFTPShell) moveRemote(args []word) {
 	if len(args) < 2 {
-		fmt.Println("Usage: mv <source> <destination>")
+		fmt.Println("Usage: mv <source>... <destination>")
 		return
 	}
 
-	oldPath := s.resolvePath(args[0])
-	newPath := s.resolvePath(args[1])
+	var sources []string
+	for _, arg := range args[:len(args)-1] {
+		paths, err := s.expandRemote(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		sources = append(sources, paths...)
+	}
+	dst := s.resolvePath(args[len(args)-1].text)
+
+	if len(sources) > 1 {
+		if info, err := s.client.Stat(dst); err != nil || !info.IsDir() {
+			fmt.Printf("Error: %s is not a directory\n", dst)
+			return
+		}
+		for _, src := range sources {
+			s.moveRemotePath(src, filepath.Join(dst, filepath.Base(src)))
+		}
+		return
+	}
+	s.moveRemotePath(sources[0], dst)
+}
 
+func (s *SFTPShell) moveRemotePath(oldPath, newPath string) {
 	err := s.client.Rename(oldPath, newPath)
 	if err != nil {
 		fmt.Printf("Error moving: %v\n", err)
@@ -412,8 +477,22 @@ func (s *SFTPShell) resolvePath(path string) string {
 	return filepath.Join(s.pwd, path)
 }
 
+// newProgressBar returns the progress bar shown on stderr for transfers.
+func newProgressBar(total int64, description string) *progressbar.ProgressBar {
+	return progressbar.NewOptions64(
+		total,
+		progressbar.OptionSetDescription(description),
+		progressbar.OptionSetWriter(os.Stderr),
+		progressbar.OptionShowCount(),
+		progressbar.OptionShowBytes(true),
+		progressbar.OptionSetWidth(40),
+		progressbar.OptionThrottle(100*time.Millisecond),
+	)
+}
+
 // progressReader wraps an io.Reader to track progress for uploads.
 // It implements Size() to enable concurrent writes in sftp.File.ReadFrom.
+// A bar set up front is shared with other transfers instead of created.
 type progressReader struct {
 	reader      io.Reader
 	total       int64
@@ -432,15 +511,9 @@ func (pr *progressReader) Size() int64 {
 
 func (pr *progressReader) Read(p []byte) (int, error) {
 	pr.once.Do(func() {
-		pr.bar = progressbar.NewOptions64(
-			pr.total,
-			progressbar.OptionSetDescription(pr.description),
-			progressbar.OptionSetWriter(os.Stderr),
-			progressbar.OptionShowCount(),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionSetWidth(40),
-			progressbar.OptionThrottle(100*time.Millisecond),
-		)
+		if pr.bar == nil {
+			pr.bar = newProgressBar(pr.total, pr.description)
+		}
 	})
 
 	n, err := pr.reader.Read(p)
@@ -456,6 +529,7 @@ func (pr *progressReader) Read(p []byte) (int, error) {
 }
 
 // progressWriter wraps an io.Writer to track progress for downloads.
+// A bar set up front is shared with other transfers instead of created.
 type progressWriter struct {
 	writer      io.Writer
 	total       int64
@@ -468,15 +542,9 @@ type progressWriter struct {
 
 func (pw *progressWriter) Write(p []byte) (int, error) {
 	pw.once.Do(func() {
-		pw.bar = progressbar.NewOptions64(
-			pw.total,
-			progressbar.OptionSetDescription(pw.description),
-			progressbar.OptionSetWriter(os.Stderr),
-			progressbar.OptionShowCount(),
-			progressbar.OptionShowBytes(true),
-			progressbar.OptionSetWidth(40),
-			progressbar.OptionThrottle(100*time.Millisecond),
-		)
+		if pw.bar == nil {
+			pw.bar = newProgressBar(pw.total, pw.description)
+		}
 	})
 
 	n, err := pw.writer.Write(p)
@@ -0,0 +1,164 @@
+package sshw
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/atrox/homedir"
+)
+
+// sftpCommands are the command names completed by the SFTP shell.
+var sftpCommands = []string{
+	"bye", "cd", "chgrp", "chmod", "chown", "exit", "get", "help", "lcd", "ll",
+	"lls", "lmkdir", "lmv", "ln", "lpwd", "lrm", "ls", "mget", "mkdir", "mput",
+	"mv", "put", "pwd", "quit", "reget", "reput", "rm", "stat", "symlink",
+}
+
+// sftpCompleter completes command names, and remote or local paths for the
+// arguments depending on the command.
+type sftpCompleter struct {
+	shell *SFTPShell
+}
+
+// Do implements readline.AutoCompleter: it returns what can be appended at
+// pos and how many runes of the word before pos the candidates share.
+func (c *sftpCompleter) Do(line []rune, pos int) ([][]rune, int) {
+	words, word, quote := splitPartial(string(line[:pos]))
+	if len(words) == 0 {
+		var candidates [][]rune
+		for _, name := range sftpCommands {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, []rune(name[len(word):]+" "))
+			}
+		}
+		return candidates, len([]rune(word))
+	}
+
+	dir, base := "", word
+	if i := strings.LastIndex(word, "/"); i >= 0 {
+		dir, base = word[:i+1], word[i+1:]
+	}
+	entries := c.list(dir, completesLocal(words))
+
+	var candidates [][]rune
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, base) || (strings.HasPrefix(name, ".") && !strings.HasPrefix(base, ".")) {
+			continue
+		}
+		end := " "
+		switch {
+		case entry.IsDir():
+			end = "/"
+		case quote != 0:
+			end = string(quote) + " "
+		}
+		candidates = append(candidates, []rune(escapeWord(name[len(base):], quote)+end))
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return string(candidates[i]) < string(candidates[j])
+	})
+	return candidates, len([]rune(base))
+}
+
+// list returns the entries of the remote or local directory dir as typed
+// on the command line.
+func (c *sftpCompleter) list(dir string, local bool) []os.FileInfo {
+	s := c.shell
+	if !local {
+		entries, _ := s.client.ReadDir(s.resolvePath(dir))
+		return entries
+	}
+
+	if strings.HasPrefix(dir, "~") {
+		dir, _ = homedir.Expand(dir)
+	}
+	entries, _ := os.ReadDir(s.resolveLocalPath(dir))
+	var infos []os.FileInfo
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			infos = append(infos, info)
+		}
+	}
+	return infos
+}
+
+// completesLocal reports whether the next argument of the command words is
+// a local path: every argument of the l* commands, the sources of mput and
+// the target directory of mget, and the first operand of put and reput.
+func completesLocal(words []string) bool {
+	switch strings.ToLower(words[0]) {
+	case "lcd", "lls", "lmkdir", "lrm", "lmv":
+		return true
+	case "mget":
+		return words[len(words)-1] == "-d"
+	case "mput":
+		return words[len(words)-1] != "-d"
+	case "put", "reput":
+		for _, w := range words[1:] {
+			if !strings.HasPrefix(w, "-") {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// splitPartial splits an unfinished command line like splitCommandLine into
+// the complete words, the word being typed with quotes and escapes removed,
+// and the quote left open in it, if any.
+func splitPartial(line string) (words []string, word string, quote rune) {
+	var b strings.Builder
+	inWord, escaped := false, false
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+			b.WriteRune(r)
+		case r == '\\' && quote != '\'':
+			escaped = true
+			inWord = true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				b.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote = r
+			inWord = true
+		case unicode.IsSpace(r):
+			if inWord {
+				words = append(words, b.String())
+				b.Reset()
+				inWord = false
+			}
+		default:
+			b.WriteRune(r)
+			inWord = true
+		}
+	}
+	return words, b.String(), quote
+}
+
+// escapeWord escapes s for insertion into a word left open with quote.
+func escapeWord(s string, quote rune) string {
+	special := " \t'\"\\*?["
+	switch quote {
+	case '\'':
+		return s
+	case '"':
+		special = "\"\\$`"
+	}
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune(special, r) {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
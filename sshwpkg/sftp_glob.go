@@ -0,0 +1,220 @@
+package sshw
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+)
+
+// expandRemote resolves w against the remote working directory and
+// expands it when it is a glob. A glob that matches nothing is an error.
+func (s *SFTPShell) expandRemote(w word) ([]string, error) {
+	if !w.glob() {
+		return []string{s.resolvePath(w.text)}, nil
+	}
+	matches, err := s.client.Glob(s.resolvePath(w.pattern))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", w.text, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no match", w.text)
+	}
+	// the server returns directory entries unsorted
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// expandLocal resolves w against the local working directory and expands
+// it when it is a glob. A glob that matches nothing is an error.
+func (s *SFTPShell) expandLocal(w word) ([]string, error) {
+	if !w.glob() {
+		return []string{s.resolveLocalPath(w.text)}, nil
+	}
+	matches, err := filepath.Glob(s.resolveLocalPath(w.pattern))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", w.text, err)
+	}
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("%s: no match", w.text)
+	}
+	return matches, nil
+}
+
+// resolveLocalPath resolves relative paths against current local directory
+func (s *SFTPShell) resolveLocalPath(p string) string {
+	if filepath.IsAbs(p) {
+		return p
+	}
+	return filepath.Join(s.localPwd, p)
+}
+
+// listRemoteMatches lists the remote files matching pattern
+func (s *SFTPShell) listRemoteMatches(w word) {
+	paths, err := s.expandRemote(w)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	var files []os.FileInfo
+	for _, p := range paths {
+		info, err := s.client.Lstat(p)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			continue
+		}
+		files = append(files, info)
+	}
+	s.printFileList(files)
+}
+
+// mget downloads several remote files or globs into a local directory,
+// the local working directory unless one is given with -d
+func (s *SFTPShell) mget(args []word) {
+	dir, args, err := cutDirOption(args)
+	var flags map[rune]bool
+	if err == nil {
+		flags, args, err = splitFlags(args, "racp")
+	}
+	if err != nil || len(args) == 0 {
+		fmt.Println("Usage: mget [-r] [-a] [-c] [-p] [-d local-dir] <remote>...")
+		return
+	}
+
+	target := s.localPwd
+	if dir != "" {
+		target = s.resolveLocalPath(dir)
+	}
+	var sources []string
+	for _, arg := range args {
+		paths, err := s.expandRemote(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		sources = append(sources, paths...)
+	}
+	s.getMany(sources, target, flags['r'], s.transferOptions(false, flags))
+}
+
+// mput uploads several local files or globs into a remote directory, the
+// remote working directory unless one is given with -d
+func (s *SFTPShell) mput(args []word) {
+	dir, args, err := cutDirOption(args)
+	var flags map[rune]bool
+	if err == nil {
+		flags, args, err = splitFlags(args, "racp")
+	}
+	if err != nil || len(args) == 0 {
+		fmt.Println("Usage: mput [-r] [-a] [-c] [-p] [-d remote-dir] <local>...")
+		return
+	}
+
+	target := s.pwd
+	if dir != "" {
+		target = s.resolvePath(dir)
+	}
+	var sources []string
+	for _, arg := range args {
+		paths, err := s.expandLocal(arg)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			return
+		}
+		sources = append(sources, paths...)
+	}
+	s.putMany(sources, target, flags['r'], s.transferOptions(false, flags))
+}
+
+// cutDirOption removes the option "-d <dir>" from the options leading args
+// and returns dir, empty when the option is not given.
+func cutDirOption(args []word) (string, []word, error) {
+	for i := 0; i < len(args) && len(args[i].text) > 1 && args[i].text[0] == '-' && args[i].text != "--"; i++ {
+		if args[i].text != "-d" {
+			continue
+		}
+		if i+1 == len(args) {
+			return "", nil, errors.New("option -d requires a directory")
+		}
+		return args[i+1].text, append(args[:i:i], args[i+2:]...), nil
+	}
+	return "", args, nil
+}
+
+// getMany downloads every remote source into the local directory dir. A
+// source that fails does not stop the others.
+func (s *SFTPShell) getMany(sources []string, dir string, recursive bool, opts transferOptions) {
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Printf("Error: %s is not a directory\n", dir)
+		return
+	}
+
+	var files int
+	var bytesWritten int64
+	var errs []error
+	for _, src := range sources {
+		to := filepath.Join(dir, path.Base(src))
+		info, err := s.client.Stat(src)
+		switch {
+		case err != nil:
+			errs = append(errs, fmt.Errorf("%s: %w", src, err))
+		case info.IsDir() && !recursive:
+			errs = append(errs, fmt.Errorf("%s is a directory (use -r)", src))
+		case info.IsDir():
+			n, written, treeErrs := downloadTree(s.client, src, to, opts)
+			files += n
+			bytesWritten += written
+			errs = append(errs, treeErrs...)
+		default:
+			written, err := download(s.client, src, to, opts)
+			bytesWritten += written
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			files++
+		}
+	}
+	fmt.Printf("Download complete: %s (%d files, %.2f MB)\n", dir, files, float64(bytesWritten)/1024/1024)
+	printErrors(errs)
+}
+
+// putMany uploads every local source into the remote directory dir. A
+// source that fails does not stop the others.
+func (s *SFTPShell) putMany(sources []string, dir string, recursive bool, opts transferOptions) {
+	if info, err := s.client.Stat(dir); err != nil || !info.IsDir() {
+		fmt.Printf("Error: %s is not a directory\n", dir)
+		return
+	}
+
+	var files int
+	var bytesWritten int64
+	var errs []error
+	for _, src := range sources {
+		to := path.Join(dir, filepath.Base(src))
+		info, err := os.Stat(src)
+		switch {
+		case err != nil:
+			errs = append(errs, err)
+		case info.IsDir() && !recursive:
+			errs = append(errs, fmt.Errorf("%s is a directory (use -r)", src))
+		case info.IsDir():
+			n, written, treeErrs := uploadTree(s.client, src, to, opts)
+			files += n
+			bytesWritten += written
+			errs = append(errs, treeErrs...)
+		default:
+			written, err := upload(s.client, src, to, opts)
+			bytesWritten += written
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			files++
+		}
+	}
+	fmt.Printf("Upload complete: %s (%d files, %.2f MB)\n", dir, files, float64(bytesWritten)/1024/1024)
+	printErrors(errs)
+}
@@ -0,0 +1,123 @@
+package sshw
+
+import (
+	"errors"
+	"strings"
+	"unicode"
+)
+
+// word is a word of an SFTP shell command line.
+type word struct {
+	text    string // the word with quotes and escapes removed
+	pattern string // glob pattern form, empty without unquoted metacharacters
+}
+
+// glob reports whether w has unquoted glob metacharacters.
+func (w word) glob() bool {
+	return w.pattern != ""
+}
+
+// texts returns the text of every word, for commands that do not expand
+// globs.
+func texts(words []word) []string {
+	var s []string
+	for _, w := range words {
+		s = append(s, w.text)
+	}
+	return s
+}
+
+// splitCommandLine splits a shell command line into words like a POSIX
+// shell: blanks separate words, single quotes keep everything literal,
+// double quotes keep everything but \" \\ \$ and \` literal, and a backslash
+// outside quotes escapes the next character.
+//
+// Words with unquoted glob metacharacters also get a pattern form, in which
+// quoted metacharacters are escaped with a backslash so that they match
+// only themselves.
+func splitCommandLine(line string) ([]word, error) {
+	const (
+		unquoted = iota
+		single
+		double
+	)
+	var (
+		words         []word
+		text, pattern strings.Builder
+		inWord, glob  bool
+		state         = unquoted
+		escaped       bool
+	)
+	// add appends r to the current word; quoted metacharacters are escaped
+	// in the pattern form.
+	add := func(r rune, quoted bool) {
+		inWord = true
+		text.WriteRune(r)
+		if quoted && strings.ContainsRune(`*?[\`, r) {
+			pattern.WriteByte('\\')
+		} else if !quoted && strings.ContainsRune(`*?[`, r) {
+			glob = true
+		}
+		pattern.WriteRune(r)
+	}
+	end := func() {
+		if !inWord {
+			return
+		}
+		w := word{text: text.String()}
+		if glob {
+			w.pattern = pattern.String()
+		}
+		words = append(words, w)
+		text.Reset()
+		pattern.Reset()
+		inWord, glob = false, false
+	}
+
+	for _, r := range line {
+		switch {
+		case escaped:
+			escaped = false
+			if state == double && !strings.ContainsRune("\"\\$`", r) {
+				add('\\', true)
+			}
+			add(r, true)
+		case r == '\\' && state != single:
+			escaped = true
+			inWord = true
+		case state == single:
+			if r == '\'' {
+				state = unquoted
+			} else {
+				add(r, true)
+			}
+		case state == double:
+			if r == '"' {
+				state = unquoted
+			} else {
+				add(r, true)
+			}
+		case r == '\'':
+			state = single
+			inWord = true
+		case r == '"':
+			state = double
+			inWord = true
+		case unicode.IsSpace(r):
+			end()
+		default:
+			add(r, false)
+		}
+	}
+
+	switch {
+	case escaped:
+		return nil, errors.New("unexpected end of line after backslash")
+	case state == single:
+		return nil, errors.New("unterminated single quote")
+	case state == double:
+		return nil, errors.New("unterminated double quote")
+	}
+	end()
+	return words, nil
+}
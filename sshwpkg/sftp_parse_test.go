@@ -0,0 +1,47 @@
+package sshw
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitCommandLine(t *testing.T) {
+	tests := []struct {
+		line  string
+		words []word
+		err   string
+	}{
+		{line: "", words: nil},
+		{line: "  get  a.txt\tb.txt ", words: []word{{text: "get"}, {text: "a.txt"}, {text: "b.txt"}}},
+		{line: `get 'my file' "other file" my\ file`, words: []word{{text: "get"}, {text: "my file"}, {text: "other file"}, {text: "my file"}}},
+		{line: `rm ''`, words: []word{{text: "rm"}, {text: ""}}},
+		{line: `rm "" x`, words: []word{{text: "rm"}, {text: ""}, {text: "x"}}},
+		{line: `ls *.log`, words: []word{{text: "ls"}, {text: "*.log", pattern: "*.log"}}},
+		{line: `rm 'x*'`, words: []word{{text: "rm"}, {text: "x*"}}},
+		{line: `rm 'x*' x*`, words: []word{{text: "rm"}, {text: "x*"}, {text: "x*", pattern: "x*"}}},
+		{line: `rm \*`, words: []word{{text: "rm"}, {text: "*"}}},
+		{line: `rm 'a*'*`, words: []word{{text: "rm"}, {text: "a**", pattern: `a\**`}}},
+		{line: `rm "a\b"*`, words: []word{{text: "rm"}, {text: `a\b*`, pattern: `a\\b*`}}},
+		{line: `echo "say \"hi\""`, words: []word{{text: "echo"}, {text: `say "hi"`}}},
+		{line: `echo 'it''s'`, words: []word{{text: "echo"}, {text: "its"}}},
+		{line: `get 'unterminated`, err: "unterminated single quote"},
+		{line: `get "unterminated`, err: "unterminated double quote"},
+		{line: `get file\`, err: "unexpected end of line after backslash"},
+	}
+	for _, tt := range tests {
+		words, err := splitCommandLine(tt.line)
+		if tt.err != "" {
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("splitCommandLine(%q) error = %v, want %q", tt.line, err, tt.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("splitCommandLine(%q) error = %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(words, tt.words) {
+			t.Errorf("splitCommandLine(%q) = %+v, want %+v", tt.line, words, tt.words)
+		}
+	}
+}
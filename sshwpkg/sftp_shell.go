@@ -1,22 +1,24 @@
 package sshw
 
 import (
-	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
 	"strings"
 
+	"github.com/chzyer/readline"
 	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
 )
 
 // SFTPShell manages the interactive SFTP session
 type SFTPShell struct {
 	client   *sftp.Client
+	ssh      *ssh.Client // optional, computes checksums on the remote side
 	node     *Node
 	pwd      string // Current working directory on remote
 	localPwd string // Current working directory on local
-	reader   *bufio.Reader
 	running  bool
 }
 
@@ -36,27 +38,38 @@ func NewSFTPShell(client *sftp.Client, node *Node) *SFTPShell {
 		node:     node,
 		pwd:      pwd,
 		localPwd: localPwd,
-		reader:   bufio.NewReader(os.Stdin),
 		running:  true,
 	}
 }
 
 // Run starts the interactive SFTP shell
 func (s *SFTPShell) Run() {
+	rl, err := readline.NewEx(&readline.Config{
+		HistoryFile:     s.historyPath(),
+		AutoComplete:    &sftpCompleter{shell: s},
+		InterruptPrompt: "^C",
+	})
+	if err != nil {
+		l.Error(err)
+		return
+	}
+	defer rl.Close()
+
 	fmt.Printf("Connected to %s@%s\n", s.node.user(), s.node.Host)
 	fmt.Printf("Type 'help' for available commands, 'exit' or 'quit' to disconnect\n\n")
 
 	for s.running {
-		prompt := fmt.Sprintf("sftp %s:%s> ", s.node.Host, s.pwd)
-		fmt.Print(prompt)
+		rl.SetPrompt(fmt.Sprintf("sftp %s:%s> ", s.node.Host, s.pwd))
 
-		line, err := s.reader.ReadString('\n')
+		line, err := rl.Readline()
+		if err == readline.ErrInterrupt {
+			continue
+		}
 		if err != nil {
 			if err == io.EOF {
-				fmt.Println("\nConnection closed.")
-				return
+				fmt.Println("Connection closed.")
 			}
-			continue
+			return
 		}
 
 		cmd := strings.TrimSpace(line)
@@ -68,15 +81,34 @@ func (s *SFTPShell) Run() {
 	}
 }
 
+// historyPath returns the command history file of the node, kept in sshw's
+// state directory, or "" to keep the history in memory only.
+func (s *SFTPShell) historyPath() string {
+	name := fmt.Sprintf("%s@%s_%d", s.node.user(), s.node.Host, s.node.port())
+	p, err := stateDir("sftp_history", safeFileName(name))
+	if err != nil {
+		return ""
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return ""
+	}
+	return p
+}
+
 // executeCommand parses and executes SFTP commands
 func (s *SFTPShell) executeCommand(cmdLine string) {
-	parts := strings.Fields(cmdLine)
-	if len(parts) == 0 {
+	words, err := splitCommandLine(cmdLine)
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+	if len(words) == 0 {
 		return
 	}
 
-	cmd := strings.ToLower(parts[0])
-	args := parts[1:]
+	// commands that expand globs take the words, the others their text
+	cmd := strings.ToLower(words[0].text)
+	args := words[1:]
 
 	switch cmd {
 	case "help", "?":
@@ -84,31 +116,49 @@ func (s *SFTPShell) executeCommand(cmdLine string) {
 	case "ls", "ll":
 		s.listRemote(args)
 	case "cd":
-		s.changeRemoteDir(args)
+		s.changeRemoteDir(texts(args))
 	case "pwd":
 		fmt.Println(s.pwd)
 	case "lpwd":
 		fmt.Println(s.localPwd)
 	case "lcd":
-		s.changeLocalDir(args)
+		s.changeLocalDir(texts(args))
 	case "lls":
-		s.listLocal(args)
+		s.listLocal(texts(args))
 	case "get":
-		s.downloadFile(args)
+		s.downloadFile(cmd, args)
 	case "put":
-		s.uploadFile(args)
+		s.uploadFile(cmd, args)
+	case "reget":
+		s.downloadFile(cmd, args)
+	case "reput":
+		s.uploadFile(cmd, args)
+	case "chmod":
+		s.changeModes(args)
+	case "chown":
+		s.changeOwners(args)
+	case "chgrp":
+		s.changeGroups(args)
+	case "ln", "symlink":
+		s.makeLink(cmd, args)
+	case "stat":
+		s.statRemote(args)
+	case "mget":
+		s.mget(args)
+	case "mput":
+		s.mput(args)
 	case "mkdir":
-		s.makeRemoteDir(args)
+		s.makeRemoteDir(texts(args))
 	case "lmkdir":
-		s.makeLocalDir(args)
+		s.makeLocalDir(texts(args))
 	case "rm":
 		s.removeRemote(args)
 	case "lrm":
-		s.removeLocal(args)
+		s.removeLocal(texts(args))
 	case "mv":
 		s.moveRemote(args)
 	case "lmv":
-		s.moveLocal(args)
+		s.moveLocal(texts(args))
 	case "exit", "quit", "bye":
 		s.running = false
 		fmt.Println("Goodbye!")
@@ -123,12 +173,23 @@ func (s *SFTPShell) showHelp() {
 Available SFTP Commands:
 
 Remote Operations:
-  ls [path]           - List remote files (optional path)
+  ls [path|glob]      - List remote files (optional path or pattern)
   cd <path>           - Change remote directory
   pwd                 - Print remote working directory
   mkdir <path>        - Create remote directory
-  rm <file>           - Remove remote file
-  mv <src> <dst>      - Move/rename remote file
+  rm <file>...        - Remove remote files
+  mv <src>... <dst>   - Move/rename remote file, or move several into <dst>
+  chmod [-R] <mode> <path>...
+                      - Change mode, octal (755) or symbolic (u+x,go-w)
+  chown [-R] <uid>[:<gid>] <path>...
+                      - Change owner and group (numeric IDs)
+  chgrp [-R] <gid> <path>...
+                      - Change group (numeric ID)
+  ln [-s] <target> <link>
+                      - Create a hard link, or a symbolic link with -s
+  symlink <target> <link>
+                      - Create a symbolic link
+  stat <path>...      - Show attributes, owner and group IDs and link target
 
 Local Operations:
   lls [path]          - List local files
@@ -139,8 +200,31 @@ Local Operations:
   lmv <src> <dst>     - Move/rename local file
 
 File Transfer:
-  get <remote> [local]  - Download file from remote
-  put <local> [remote]  - Upload file to remote
+  get [-r] <remote> [local]  - Download file (or directory with -r) from remote
+  put [-r] <local> [remote]  - Upload file (or directory with -r) to remote
+  reget [-r] [-c] <remote> [local]
+                             - Resume a download (same as get -a)
+  reput [-r] [-c] <local> [remote]
+                             - Resume an upload (same as put -a)
+                               -c compares checksums of the part already
+                               transferred before continuing
+  mget [-r] [-d local-dir] <remote>...
+                             - Download several files into a directory,
+                               the local working directory by default
+  mput [-r] [-d remote-dir] <local>...
+                             - Upload several files into a directory,
+                               the remote working directory by default
+
+  Transfers take -p to keep modes and access/modification times (the default
+  for nodes with preserve: true).
+
+Remote paths of get, mget, ls, rm and mv and local paths of put and mput may
+be glob patterns such as *.log; get and put download or upload every match
+into the target directory.
+
+Arguments are split like in a shell: quote names with spaces as 'my file' or
+"my file", or escape single characters with a backslash (my\ file). Quoted
+glob characters match only themselves.
 
 General:
   help, ?             - Show this help message
@@ -0,0 +1,11 @@
+//go:build !windows
+
+package sshw
+
+import "os/exec"
+
+// shellCommand runs command through /bin/sh, replacing the shell with the
+// command like OpenSSH does for ProxyCommand.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", "exec "+command)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package sshw
+
+import "os/exec"
+
+// shellCommand runs command through cmd.exe.
+func shellCommand(command string) *exec.Cmd {
+	return exec.Command("cmd", "/C", command)
+}
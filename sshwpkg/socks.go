@@ -0,0 +1,184 @@
+package sshw
+
+import (
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DynamicForward declares a SOCKS5 proxy (-D) whose CONNECT requests are
+// dialed through the node. User and Password enable RFC 1929 authentication.
+type DynamicForward struct {
+	Name     string `yaml:"name"`
+	Listen   string `yaml:"listen"`
+	User     string `yaml:"user"`
+	Password string `yaml:"password"`
+}
+
+func (d *DynamicForward) describe() string {
+	if d.Name != "" {
+		return d.Name
+	}
+	return "socks5://" + listenAddr(d.Listen)
+}
+
+const (
+	socksVersion   = 0x05
+	socksAuthNone  = 0x00
+	socksAuthPass  = 0x02
+	socksNoMethods = 0xff
+	socksConnect   = 0x01
+
+	socksAddrIPv4   = 0x01
+	socksAddrDomain = 0x03
+	socksAddrIPv6   = 0x04
+
+	socksSucceeded          = 0x00
+	socksGeneralFailure     = 0x01
+	socksNotAllowed         = 0x02
+	socksConnectionRefused  = 0x05
+	socksCommandUnsupported = 0x07
+	socksAddrUnsupported    = 0x08
+)
+
+// socksDial negotiates SOCKS5 on conn, dials the requested target with dial
+// and reports the outcome to the client.
+func socksDial(conn net.Conn, d *DynamicForward, dial func(network, addr string) (net.Conn, error)) (net.Conn, error) {
+	conn.SetDeadline(time.Now().Add(30 * time.Second))
+	target, err := socksHandshake(conn, d.User, d.Password)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+
+	upstream, err := dial("tcp", target)
+	if err != nil {
+		code := byte(socksGeneralFailure)
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) {
+			switch openErr.Reason {
+			case ssh.ConnectionFailed:
+				code = socksConnectionRefused
+			case ssh.Prohibited:
+				code = socksNotAllowed
+			}
+		}
+		socksReply(conn, code)
+		return nil, fmt.Errorf("connect %s: %w", target, err)
+	}
+	if err := socksReply(conn, socksSucceeded); err != nil {
+		upstream.Close()
+		return nil, err
+	}
+	return upstream, nil
+}
+
+// socksHandshake reads the greeting, optional username/password exchange
+// and CONNECT request, returning the requested host:port.
+func socksHandshake(conn net.Conn, user, password string) (string, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return "", err
+	}
+	if hdr[0] != socksVersion {
+		return "", fmt.Errorf("unsupported socks version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(conn, methods); err != nil {
+		return "", err
+	}
+
+	method := byte(socksAuthNone)
+	if user != "" || password != "" {
+		method = socksAuthPass
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == method
+	}
+	if !offered {
+		conn.Write([]byte{socksVersion, socksNoMethods})
+		return "", errors.New("socks client offered no acceptable auth method")
+	}
+	if _, err := conn.Write([]byte{socksVersion, method}); err != nil {
+		return "", err
+	}
+
+	if method == socksAuthPass {
+		// RFC 1929: ver, ulen, uname, plen, passwd
+		if _, err := io.ReadFull(conn, hdr); err != nil {
+			return "", err
+		}
+		uname := make([]byte, hdr[1])
+		if _, err := io.ReadFull(conn, uname); err != nil {
+			return "", err
+		}
+		if _, err := io.ReadFull(conn, hdr[:1]); err != nil {
+			return "", err
+		}
+		passwd := make([]byte, hdr[0])
+		if _, err := io.ReadFull(conn, passwd); err != nil {
+			return "", err
+		}
+		userOK := subtle.ConstantTimeCompare(uname, []byte(user)) == 1
+		passOK := subtle.ConstantTimeCompare(passwd, []byte(password)) == 1
+		if !userOK || !passOK {
+			conn.Write([]byte{0x01, 0x01})
+			return "", fmt.Errorf("socks authentication failed for user %q", uname)
+		}
+		if _, err := conn.Write([]byte{0x01, 0x00}); err != nil {
+			return "", err
+		}
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return "", err
+	}
+	if req[1] != socksConnect {
+		socksReply(conn, socksCommandUnsupported)
+		return "", fmt.Errorf("unsupported socks command %d", req[1])
+	}
+
+	var host string
+	switch req[3] {
+	case socksAddrIPv4, socksAddrIPv6:
+		ip := make(net.IP, 4)
+		if req[3] == socksAddrIPv6 {
+			ip = make(net.IP, 16)
+		}
+		if _, err := io.ReadFull(conn, ip); err != nil {
+			return "", err
+		}
+		host = ip.String()
+	case socksAddrDomain:
+		if _, err := io.ReadFull(conn, hdr[:1]); err != nil {
+			return "", err
+		}
+		domain := make([]byte, hdr[0])
+		if _, err := io.ReadFull(conn, domain); err != nil {
+			return "", err
+		}
+		host = string(domain)
+	default:
+		socksReply(conn, socksAddrUnsupported)
+		return "", fmt.Errorf("unsupported socks address type %d", req[3])
+	}
+
+	if _, err := io.ReadFull(conn, hdr); err != nil {
+		return "", err
+	}
+	port := int(hdr[0])<<8 | int(hdr[1])
+	return net.JoinHostPort(host, strconv.Itoa(port)), nil
+}
+
+func socksReply(conn net.Conn, code byte) error {
+	_, err := conn.Write([]byte{socksVersion, code, 0x00, socksAddrIPv4, 0, 0, 0, 0, 0, 0})
+	return err
+}
@@ -0,0 +1,111 @@
+package sshw
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+)
+
+func TestSocksHandshake(t *testing.T) {
+	connect := []byte{socksVersion, socksConnect, 0x00, socksAddrDomain, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'o', 'r', 'g', 0x01, 0xbb}
+	auth := func(user, password string) []byte {
+		b := []byte{0x01, byte(len(user))}
+		b = append(b, user...)
+		b = append(b, byte(len(password)))
+		return append(b, password...)
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+
+	tests := []struct {
+		name           string
+		user, password string
+		client         []byte
+		target         string
+		replies        []byte
+		wantErr        bool
+	}{
+		{
+			name:    "no auth",
+			client:  join([]byte{socksVersion, 1, socksAuthNone}, connect),
+			target:  "example.org:443",
+			replies: []byte{socksVersion, socksAuthNone},
+		},
+		{
+			name:    "ipv4 target",
+			client:  []byte{socksVersion, 1, socksAuthNone, socksVersion, socksConnect, 0x00, socksAddrIPv4, 10, 0, 0, 1, 0x00, 0x16},
+			target:  "10.0.0.1:22",
+			replies: []byte{socksVersion, socksAuthNone},
+		},
+		{
+			name:     "password accepted",
+			user:     "alice",
+			password: "secret",
+			client:   join([]byte{socksVersion, 2, socksAuthNone, socksAuthPass}, auth("alice", "secret"), connect),
+			target:   "example.org:443",
+			replies:  []byte{socksVersion, socksAuthPass, 0x01, 0x00},
+		},
+		{
+			name:     "wrong password",
+			user:     "alice",
+			password: "secret",
+			client:   join([]byte{socksVersion, 1, socksAuthPass}, auth("alice", "guess"), connect),
+			replies:  []byte{socksVersion, socksAuthPass, 0x01, 0x01},
+			wantErr:  true,
+		},
+		{
+			name:     "wrong user",
+			user:     "alice",
+			password: "secret",
+			client:   join([]byte{socksVersion, 1, socksAuthPass}, auth("bob", "secret"), connect),
+			replies:  []byte{socksVersion, socksAuthPass, 0x01, 0x01},
+			wantErr:  true,
+		},
+		{
+			name:     "password required",
+			user:     "alice",
+			password: "secret",
+			client:   join([]byte{socksVersion, 1, socksAuthNone}, connect),
+			replies:  []byte{socksVersion, socksNoMethods},
+			wantErr:  true,
+		},
+		{
+			name:    "socks4",
+			client:  []byte{0x04, 0x01, 0x00, 0x50},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			go func() {
+				client.Write(tt.client)
+			}()
+			replies := make(chan []byte)
+			go func() {
+				b, _ := io.ReadAll(client)
+				replies <- b
+			}()
+
+			target, err := socksHandshake(server, tt.user, tt.password)
+			server.Close()
+			got := <-replies
+			client.Close()
+
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("handshake succeeded with target %s, want an error", target)
+				}
+			} else if err != nil {
+				t.Errorf("handshake error = %v", err)
+			} else if target != tt.target {
+				t.Errorf("target = %s, want %s", target, tt.target)
+			}
+			if !bytes.Equal(got, tt.replies) {
+				t.Errorf("replies = %v, want %v", got, tt.replies)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package sshw
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// TmuxWindow is a command to run in a tmux window or pane of its own.
+type TmuxWindow struct {
+	Name    string
+	Command string
+}
+
+// InTmux reports whether sshw runs inside a tmux session.
+func InTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// OpenTmux runs every command in a new window of the current tmux session,
+// or with panes set in the tiled panes of one new window, whose panes are
+// optionally synchronized so that keystrokes reach all of them.
+func OpenTmux(windows []TmuxWindow, panes, sync bool) error {
+	if !InTmux() {
+		return errors.New("not running inside tmux")
+	}
+	if !panes {
+		for _, w := range windows {
+			if _, err := tmux("new-window", "-n", w.Name, w.Command); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	var window string
+	for i, w := range windows {
+		var out string
+		var err error
+		if i == 0 {
+			out, err = tmux("new-window", "-P", "-F", "#{window_id} #{pane_id}", "-n", "sshw", w.Command)
+		} else {
+			out, err = tmux("split-window", "-P", "-F", "#{window_id} #{pane_id}", "-t", window, w.Command)
+		}
+		if err != nil {
+			return err
+		}
+		ids := strings.Fields(out)
+		if len(ids) != 2 {
+			return fmt.Errorf("unexpected tmux output %q", out)
+		}
+		window = ids[0]
+		tmux("select-pane", "-t", ids[1], "-T", w.Name)
+		// retile after every split so the window never runs out of space
+		if _, err := tmux("select-layout", "-t", window, "tiled"); err != nil {
+			return err
+		}
+	}
+	tmux("set-window-option", "-t", window, "pane-border-status", "top")
+	tmux("set-window-option", "-t", window, "pane-border-format", " #{pane_title} ")
+	if sync {
+		if _, err := tmux("set-window-option", "-t", window, "synchronize-panes", "on"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tmux runs a tmux command and returns its trimmed output.
+func tmux(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("tmux", args...)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("tmux %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("tmux %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
@@ -0,0 +1,533 @@
+package sshw
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"github.com/schollz/progressbar/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+// transferOptions controls a single file transfer.
+type transferOptions struct {
+	// resume continues a shorter target file instead of starting over.
+	resume bool
+	// verify compares checksums of the part already transferred before a
+	// transfer is resumed.
+	verify bool
+	// bar, when set, is shared with other transfers instead of each file
+	// showing a bar of its own.
+	bar *progressbar.ProgressBar
+	// ssh, when set, computes remote checksums on the node itself.
+	ssh *ssh.Client
+	// preserve copies the mode and the access and modification times of
+	// the source.
+	preserve bool
+}
+
+// preservedMode selects the mode bits kept by a transfer with preserve.
+const preservedMode = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// resumeOffset returns where a transfer of a size bytes long source continues
+// into the existing target.
+func resumeOffset(size int64, target os.FileInfo) (int64, error) {
+	switch {
+	case !target.Mode().IsRegular():
+		return 0, fmt.Errorf("%s is not a regular file, cannot resume", target.Name())
+	case target.Size() > size:
+		return 0, fmt.Errorf("%s is larger than the source, cannot resume", target.Name())
+	}
+	return target.Size(), nil
+}
+
+// download copies the remote file to localPath and returns the number of
+// bytes written.
+func download(client *sftp.Client, remotePath, localPath string, opts transferOptions) (int64, error) {
+	srcFile, err := client.Open(remotePath)
+	if err != nil {
+		return 0, fmt.Errorf("opening remote file: %w", err)
+	}
+	defer srcFile.Close()
+
+	var srcInfo os.FileInfo
+	var fileSize int64
+	if info, err := srcFile.Stat(); err == nil {
+		srcInfo, fileSize = info, info.Size()
+	}
+
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.resume {
+		if info, err := os.Stat(localPath); err == nil {
+			if offset, err = resumeOffset(fileSize, info); err != nil {
+				return 0, err
+			}
+			flags = os.O_WRONLY
+		}
+		if offset > 0 && opts.verify {
+			if err := samePrefix(client, opts.ssh, remotePath, localPath, offset); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	dstFile, err := os.OpenFile(localPath, flags, 0666)
+	if err != nil {
+		return 0, fmt.Errorf("creating local file: %w", err)
+	}
+	defer dstFile.Close()
+	preserve := func() error {
+		if !opts.preserve || srcInfo == nil {
+			return nil
+		}
+		dstFile.Close()
+		return preserveLocal(localPath, srcInfo, remoteAtime(srcInfo))
+	}
+	if done := skipDone(localPath, offset, fileSize, opts.bar); done {
+		return 0, preserve()
+	}
+	if err := seekBoth(srcFile, dstFile, offset); err != nil {
+		return 0, err
+	}
+
+	// Wrap dstFile with progress tracking
+	progressDst := &progressWriter{
+		writer:      dstFile,
+		total:       fileSize - offset,
+		description: fmt.Sprintf("%s %s", verb("Downloading", offset), path.Base(remotePath)),
+		bar:         opts.bar,
+	}
+
+	// Use WriteTo for optimized concurrent reads from remote server
+	n, err := srcFile.WriteTo(progressDst)
+	if opts.bar == nil {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+	if err != nil {
+		// Truncate local file to avoid data holes when transfer fails
+		if info, statErr := dstFile.Stat(); statErr == nil {
+			_ = dstFile.Truncate(info.Size())
+		}
+		return n, fmt.Errorf("downloading %s: %w", remotePath, err)
+	}
+	return n, preserve()
+}
+
+// upload copies the local file to remotePath and returns the number of
+// bytes written.
+func upload(client *sftp.Client, localPath, remotePath string, opts transferOptions) (int64, error) {
+	srcFile, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("opening local file: %w", err)
+	}
+	defer srcFile.Close()
+
+	var srcInfo os.FileInfo
+	var fileSize int64
+	if info, err := srcFile.Stat(); err == nil {
+		srcInfo, fileSize = info, info.Size()
+	}
+
+	var offset int64
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if opts.resume {
+		if info, err := client.Stat(remotePath); err == nil {
+			if offset, err = resumeOffset(fileSize, info); err != nil {
+				return 0, err
+			}
+			flags = os.O_WRONLY
+		}
+		if offset > 0 && opts.verify {
+			if err := samePrefix(client, opts.ssh, remotePath, localPath, offset); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	dstFile, err := client.OpenFile(remotePath, flags)
+	if err != nil {
+		return 0, fmt.Errorf("creating remote file: %w", err)
+	}
+	defer dstFile.Close()
+	preserve := func() error {
+		if !opts.preserve || srcInfo == nil {
+			return nil
+		}
+		dstFile.Close()
+		return preserveRemote(client, remotePath, srcInfo, localAtime(srcInfo))
+	}
+	if done := skipDone(remotePath, offset, fileSize, opts.bar); done {
+		return 0, preserve()
+	}
+	if err := seekBoth(srcFile, dstFile, offset); err != nil {
+		return 0, err
+	}
+
+	// progressReader implements Size() which enables sftp.File.ReadFrom to use concurrent writes
+	progressSrc := &progressReader{
+		reader:      srcFile,
+		total:       fileSize - offset,
+		description: fmt.Sprintf("%s %s", verb("Uploading", offset), filepath.Base(localPath)),
+		bar:         opts.bar,
+	}
+
+	// Use ReadFrom for optimized concurrent writes to remote server
+	n, err := dstFile.ReadFrom(progressSrc)
+	if opts.bar == nil {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+	if err != nil {
+		// Truncate remote file to avoid data holes when concurrent write fails
+		if info, statErr := dstFile.Stat(); statErr == nil {
+			_ = dstFile.Truncate(info.Size())
+		}
+		return n, fmt.Errorf("uploading %s: %w", localPath, err)
+	}
+	return n, preserve()
+}
+
+// preserveLocal gives the local file p the mode and times of the source
+// described by info.
+func preserveLocal(p string, info os.FileInfo, atime time.Time) error {
+	if err := os.Chmod(p, info.Mode()&preservedMode); err != nil {
+		return fmt.Errorf("preserving mode of %s: %w", p, err)
+	}
+	if err := os.Chtimes(p, atime, info.ModTime()); err != nil {
+		return fmt.Errorf("preserving times of %s: %w", p, err)
+	}
+	return nil
+}
+
+// preserveRemote gives the remote file p the mode and times of the source
+// described by info.
+func preserveRemote(client *sftp.Client, p string, info os.FileInfo, atime time.Time) error {
+	if err := client.Chmod(p, info.Mode()&preservedMode); err != nil {
+		return fmt.Errorf("preserving mode of %s: %w", p, err)
+	}
+	if err := client.Chtimes(p, atime, info.ModTime()); err != nil {
+		return fmt.Errorf("preserving times of %s: %w", p, err)
+	}
+	return nil
+}
+
+// remoteAtime returns the access time of a remote file, or its
+// modification time when the server did not send it.
+func remoteAtime(info os.FileInfo) time.Time {
+	if st, ok := info.Sys().(*sftp.FileStat); ok {
+		return time.Unix(int64(st.Atime), 0)
+	}
+	return info.ModTime()
+}
+
+// skipDone reports whether a resumed transfer has nothing left to copy. The
+// part transferred before counts as done on a shared bar.
+func skipDone(target string, offset, size int64, bar *progressbar.ProgressBar) bool {
+	if bar != nil {
+		bar.Add64(offset)
+	}
+	if offset == 0 || offset < size {
+		return false
+	}
+	if bar == nil {
+		fmt.Fprintf(os.Stderr, "%s is already complete\n", target)
+	}
+	return true
+}
+
+// verb names a transfer for its progress bar.
+func verb(action string, offset int64) string {
+	if offset > 0 {
+		return "Resuming"
+	}
+	return action
+}
+
+// seekBoth positions source and target of a resumed transfer.
+func seekBoth(src, dst io.Seeker, offset int64) error {
+	if offset == 0 {
+		return nil
+	}
+	if _, err := src.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := dst.Seek(offset, io.SeekStart)
+	return err
+}
+
+// samePrefix checks that the first n bytes of the remote and the local file
+// are identical, so that a transfer can be resumed safely.
+func samePrefix(client *sftp.Client, sshClient *ssh.Client, remotePath, localPath string, n int64) error {
+	localSum, err := localSHA256(localPath, n)
+	if err != nil {
+		return err
+	}
+	remoteSum, err := remoteSHA256(client, sshClient, remotePath, n)
+	if err != nil {
+		return err
+	}
+	if localSum != remoteSum {
+		return fmt.Errorf("the first %d bytes of %s and %s differ, transfer it again without resuming", n, remotePath, localPath)
+	}
+	return nil
+}
+
+// localSHA256 returns the hex SHA-256 checksum of the first n bytes of the
+// local file p.
+func localSHA256(p string, n int64) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.CopyN(h, f, n); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// remoteSHA256 returns the hex SHA-256 checksum of the remote file p, or of
+// its first n bytes when n is not negative. The checksum is computed on the
+// node with sha256sum when sshClient is set and the tool is available, or
+// else, and whenever the command fails, by reading the data over sftp.
+func remoteSHA256(client *sftp.Client, sshClient *ssh.Client, p string, n int64) (string, error) {
+	if sshClient != nil {
+		q := ShellQuote(p)
+		cmd := "sha256sum < " + q
+		if n >= 0 {
+			// a pipeline exits with the status of sha256sum, which would hash
+			// nothing for a missing file, so check the file first
+			cmd = fmt.Sprintf("test -f %s && test -r %s && test $(wc -c < %s) -ge %d && head -c %d < %s | sha256sum", q, q, q, n, n, q)
+		}
+		if session, err := sshClient.NewSession(); err == nil {
+			out, err := session.Output(cmd)
+			session.Close()
+			if fields := strings.Fields(string(out)); err == nil && len(fields) > 0 && len(fields[0]) == sha256.Size*2 {
+				return fields[0], nil
+			}
+		}
+	}
+
+	f, err := client.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if n < 0 {
+		_, err = f.WriteTo(h)
+	} else {
+		_, err = io.CopyN(h, f, n)
+	}
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// downloadTree copies the remote directory tree remoteDir to localDir with
+// one progress bar for all files. A file that fails does not stop the
+// transfer; every failure is returned.
+func downloadTree(client *sftp.Client, remoteDir, localDir string, opts transferOptions) (files int, bytes int64, errs []error) {
+	opts.bar = newProgressBar(remoteTreeSize(client, remoteDir), fmt.Sprintf("Downloading %s", path.Base(remoteDir)))
+	defer finishBar(opts.bar)
+
+	// directories get their times once their contents are written
+	var dirs []preservedDir
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := preserveLocal(dirs[i].path, dirs[i].info, remoteAtime(dirs[i].info)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	walker := client.Walk(remoteDir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rel, err := relPath(remoteDir, walker.Path())
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		localPath := filepath.Join(localDir, filepath.FromSlash(rel))
+
+		info := walker.Stat()
+		mode := info.Mode()
+		if mode&fs.ModeSymlink != 0 {
+			// like scp, follow links to regular files
+			if target, err := client.Stat(walker.Path()); err == nil && target.Mode().IsRegular() {
+				mode = target.Mode()
+			}
+		}
+		switch {
+		case info.IsDir():
+			if err := os.MkdirAll(localPath, 0755); err != nil {
+				errs = append(errs, err)
+				walker.SkipDir()
+			} else if opts.preserve {
+				dirs = append(dirs, preservedDir{localPath, info})
+			}
+		case mode.IsRegular():
+			n, err := download(client, walker.Path(), localPath, opts)
+			bytes += n
+			if err != nil {
+				errs = append(errs, err)
+				continue
+			}
+			files++
+		default:
+			errs = append(errs, fmt.Errorf("%s: skipping %s", walker.Path(), fileKind(mode)))
+		}
+	}
+	return files, bytes, errs
+}
+
+// uploadTree copies the local directory tree localDir to remoteDir with one
+// progress bar for all files. A file that fails does not stop the transfer;
+// every failure is returned.
+func uploadTree(client *sftp.Client, localDir, remoteDir string, opts transferOptions) (files int, bytes int64, errs []error) {
+	opts.bar = newProgressBar(localTreeSize(localDir), fmt.Sprintf("Uploading %s", filepath.Base(localDir)))
+	defer finishBar(opts.bar)
+
+	var dirs []preservedDir
+	defer func() {
+		for i := len(dirs) - 1; i >= 0; i-- {
+			if err := preserveRemote(client, dirs[i].path, dirs[i].info, localAtime(dirs[i].info)); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}()
+
+	filepath.WalkDir(localDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		rel, err := filepath.Rel(localDir, p)
+		if err != nil {
+			errs = append(errs, err)
+			return nil
+		}
+		remotePath := path.Join(remoteDir, filepath.ToSlash(rel))
+
+		mode := d.Type()
+		if mode&fs.ModeSymlink != 0 {
+			if target, err := os.Stat(p); err == nil && target.Mode().IsRegular() {
+				mode = target.Mode()
+			}
+		}
+		switch {
+		case d.IsDir():
+			if err := client.MkdirAll(remotePath); err != nil {
+				errs = append(errs, fmt.Errorf("creating remote directory %s: %w", remotePath, err))
+				return filepath.SkipDir
+			}
+			if info, err := d.Info(); err == nil && opts.preserve {
+				dirs = append(dirs, preservedDir{remotePath, info})
+			}
+		case mode.IsRegular():
+			n, err := upload(client, p, remotePath, opts)
+			bytes += n
+			if err != nil {
+				errs = append(errs, err)
+				return nil
+			}
+			files++
+		default:
+			errs = append(errs, fmt.Errorf("%s: skipping %s", p, fileKind(mode)))
+		}
+		return nil
+	})
+	return files, bytes, errs
+}
+
+// preservedDir is a directory of a tree transfer whose mode and times are
+// set after its contents.
+type preservedDir struct {
+	path string
+	info os.FileInfo
+}
+
+// remoteTreeSize returns the total size of the files below the remote
+// directory dir, following links to regular files like downloadTree.
+func remoteTreeSize(client *sftp.Client, dir string) int64 {
+	var total int64
+	walker := client.Walk(dir)
+	for walker.Step() {
+		if walker.Err() != nil {
+			continue
+		}
+		info := walker.Stat()
+		if info.Mode()&fs.ModeSymlink != 0 {
+			if target, err := client.Stat(walker.Path()); err == nil {
+				info = target
+			}
+		}
+		if info.Mode().IsRegular() {
+			total += info.Size()
+		}
+	}
+	return total
+}
+
+// localTreeSize returns the total size of the files below the local
+// directory dir, following links to regular files like uploadTree.
+func localTreeSize(dir string) int64 {
+	var total int64
+	filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if info, err := os.Stat(p); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	return total
+}
+
+// finishBar ends the line of a progress bar shared by several transfers.
+func finishBar(bar *progressbar.ProgressBar) {
+	if bar.State().CurrentBytes > 0 {
+		fmt.Fprint(os.Stderr, "\n")
+	}
+}
+
+// relPath returns target relative to the remote directory base.
+func relPath(base, target string) (string, error) {
+	rel, err := filepath.Rel(filepath.FromSlash(base), filepath.FromSlash(target))
+	if err != nil {
+		return "", err
+	}
+	return filepath.ToSlash(rel), nil
+}
+
+// fileKind names the type of a file that is neither a directory nor a
+// regular file.
+func fileKind(mode fs.FileMode) string {
+	switch {
+	case mode&fs.ModeSymlink != 0:
+		return "symlink"
+	case mode&fs.ModeNamedPipe != 0:
+		return "named pipe"
+	case mode&fs.ModeSocket != 0:
+		return "socket"
+	case mode&fs.ModeDevice != 0:
+		return "device"
+	default:
+		return "special file"
+	}
+}
@@ -0,0 +1,195 @@
+package sshw
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// tunnelSession keeps a node's forwards running over a connection that is
+// re-established whenever it drops.
+type tunnelSession struct {
+	c         *defaultClient
+	forwarder *forwarder
+	// echo additionally receives every event when set
+	echo func(format string, args ...interface{})
+
+	mu         sync.Mutex
+	connected  bool
+	since      time.Time
+	reconnects int
+	lastErr    string
+	events     []string
+}
+
+// maxTunnelEvents is the number of recent events kept for display.
+const maxTunnelEvents = 5
+
+func newTunnelSession(c *defaultClient) *tunnelSession {
+	t := &tunnelSession{c: c}
+	t.forwarder = newForwarder(c.node, t.logf)
+	return t
+}
+
+func (t *tunnelSession) logf(format string, args ...interface{}) {
+	if t.echo != nil {
+		t.echo(format, args...)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	line := time.Now().Format("15:04:05 ") + fmt.Sprintf(format, args...)
+	t.events = append(t.events, line)
+	if len(t.events) > maxTunnelEvents {
+		t.events = t.events[len(t.events)-maxTunnelEvents:]
+	}
+}
+
+// run connects and serves the forwards until stop is closed, reconnecting
+// with exponential backoff when the connection is lost. connected receives
+// the result of the first connection; run gives up if that one fails.
+func (t *tunnelSession) run(stop <-chan struct{}, connected chan<- error) {
+	defer t.forwarder.Close()
+
+	backoff := time.Second
+	first := true
+	for {
+		client, err := t.c.dial()
+		if first {
+			connected <- err
+			first = false
+			if err != nil {
+				return
+			}
+		}
+		if err != nil {
+			t.setState(false, err.Error())
+			t.logf("connect error: %v, retrying in %s", err, backoff)
+			select {
+			case <-stop:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > time.Minute {
+				backoff = time.Minute
+			}
+			continue
+		}
+		backoff = time.Second
+
+		t.forwarder.attach(client.Client)
+		t.setState(true, "")
+		t.logf("connected to %s@%s", t.c.node.user(), t.c.node.addr())
+		go probeAlive(client.Client)
+
+		closed := make(chan struct{})
+		go func() {
+			client.Wait()
+			close(closed)
+		}()
+		select {
+		case <-stop:
+			client.Close()
+			return
+		case <-closed:
+		}
+
+		t.forwarder.detach()
+		client.Close()
+		t.setState(false, "connection lost")
+		t.logf("connection lost, reconnecting")
+		t.mu.Lock()
+		t.reconnects++
+		t.mu.Unlock()
+	}
+}
+
+func (t *tunnelSession) setState(connected bool, lastErr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if connected && !t.connected {
+		t.since = time.Now()
+	}
+	t.connected = connected
+	if lastErr != "" {
+		t.lastErr = lastErr
+	}
+}
+
+// render writes the status table of the session to b.
+func (t *tunnelSession) render(b *strings.Builder) {
+	t.mu.Lock()
+	state := "reconnecting"
+	if t.connected {
+		state = "connected since " + t.since.Format("2006-01-02 15:04:05")
+	}
+	fmt.Fprintf(b, "Tunnel %s@%s: %s, reconnects: %d\n", t.c.node.user(), t.c.node.addr(), state, t.reconnects)
+	events := append([]string(nil), t.events...)
+	t.mu.Unlock()
+
+	w := tabwriter.NewWriter(b, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TYPE\tFORWARD\tLISTEN\tACTIVE\tTOTAL\tSENT\tRECEIVED\tSTATUS")
+	for _, st := range t.forwarder.stats() {
+		status := "ok"
+		if st.Error != "" {
+			status = st.Error
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%s\t%s\t%s\n", st.Kind, st.Name, st.Listen, st.Active, st.Total, formatBytes(st.BytesOut), formatBytes(st.BytesIn), status)
+	}
+	w.Flush()
+
+	b.WriteString("\nRecent events:\n")
+	for _, e := range events {
+		fmt.Fprintf(b, "  %s\n", e)
+	}
+	b.WriteString("\nPress Ctrl+C to stop\n")
+}
+
+// formatBytes renders n with a binary unit suffix.
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// Tunnel connects to the node and serves all of its forwards without a PTY,
+// redrawing a live status table every second and reconnecting whenever the
+// connection drops. It runs until the process is interrupted.
+func (c *defaultClient) Tunnel() {
+	if len(c.node.Forwards)+len(c.node.RemoteForwards)+len(c.node.DynamicForwards) == 0 {
+		l.Errorf("no forwards, remote-forwards or dynamic-forwards declared for %s", c.node.Name)
+		return
+	}
+
+	t := newTunnelSession(c)
+	stop := make(chan struct{})
+	connected := make(chan error, 1)
+	go t.run(stop, connected)
+	if err := <-connected; err != nil {
+		l.Error(err)
+		return
+	}
+
+	lines := 0
+	for {
+		var b strings.Builder
+		t.render(&b)
+		out := b.String()
+		if lines > 0 {
+			// move back over the previous table and clear it
+			fmt.Fprintf(os.Stdout, "\033[%dA\033[J", lines)
+		}
+		fmt.Fprint(os.Stdout, out)
+		lines = strings.Count(out, "\n")
+		time.Sleep(time.Second)
+	}
+}
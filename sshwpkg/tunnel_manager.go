@@ -0,0 +1,407 @@
+package sshw
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// tunnelStatus is what a background tunnel reports over its control socket.
+type tunnelStatus struct {
+	Name       string       `json:"name"`
+	Node       string       `json:"node"`
+	PID        int          `json:"pid"`
+	Started    time.Time    `json:"started"`
+	Connected  bool         `json:"connected"`
+	Since      time.Time    `json:"since"`
+	Reconnects int          `json:"reconnects"`
+	LastError  string       `json:"last_error,omitempty"`
+	Forwards   []tunnelStat `json:"forwards"`
+}
+
+// tunnelPath returns the state file of a background tunnel with the given
+// extension (".pid", ".sock" or ".log").
+func tunnelPath(name, ext string) (string, error) {
+	return stateDir("tunnels", safeFileName(name)+ext)
+}
+
+// selectForwards returns a copy of node keeping only the forwards whose
+// name is listed; every forward is kept when names is empty.
+func selectForwards(node *Node, names []string) (*Node, error) {
+	n := *node
+	if len(names) == 0 {
+		return &n, nil
+	}
+
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = false
+	}
+	n.Forwards, n.RemoteForwards, n.DynamicForwards = nil, nil, nil
+	for _, f := range node.Forwards {
+		if _, ok := wanted[f.Name]; ok {
+			n.Forwards = append(n.Forwards, f)
+			wanted[f.Name] = true
+		}
+	}
+	for _, f := range node.RemoteForwards {
+		if _, ok := wanted[f.Name]; ok {
+			n.RemoteForwards = append(n.RemoteForwards, f)
+			wanted[f.Name] = true
+		}
+	}
+	for _, d := range node.DynamicForwards {
+		if _, ok := wanted[d.Name]; ok {
+			n.DynamicForwards = append(n.DynamicForwards, d)
+			wanted[d.Name] = true
+		}
+	}
+	for _, name := range names {
+		if !wanted[name] {
+			return nil, fmt.Errorf("no forward named %q on %s", name, node.Name)
+		}
+	}
+	return &n, nil
+}
+
+// RunTunnel serves the forwards of node (optionally only those named) in
+// the foreground, reconnecting when the connection dies, and answers status
+// and stop requests on a control socket named after the tunnel. It is the
+// body of the detached process started by StartTunnel.
+func RunTunnel(name string, node *Node, forwards []string) error {
+	node, err := selectForwards(node, forwards)
+	if err != nil {
+		return err
+	}
+	if len(node.Forwards)+len(node.RemoteForwards)+len(node.DynamicForwards) == 0 {
+		return fmt.Errorf("no forwards, remote-forwards or dynamic-forwards declared for %s", node.Name)
+	}
+
+	sockPath, err := tunnelPath(name, ".sock")
+	if err != nil {
+		return err
+	}
+	pidPath, err := tunnelPath(name, ".pid")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(sockPath), 0700); err != nil {
+		return err
+	}
+	if _, err := queryTunnel(sockPath, "status"); err == nil {
+		return fmt.Errorf("tunnel %s is already running", name)
+	}
+	os.Remove(sockPath)
+	ln, err := net.Listen("unix", sockPath)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(sockPath)
+	defer ln.Close()
+	if err := os.WriteFile(pidPath, []byte(strconv.Itoa(os.Getpid())), 0600); err != nil {
+		return err
+	}
+	defer os.Remove(pidPath)
+
+	c := genSSHConfig(node)
+	if c == nil {
+		return fmt.Errorf("cannot build ssh config for %s", node.Name)
+	}
+	t := newTunnelSession(c)
+	t.echo = l.Infof
+	started := time.Now()
+
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	shutdown := func() {
+		stopOnce.Do(func() { close(stop) })
+	}
+	done := make(chan struct{})
+	connected := make(chan error, 1)
+
+	// shut down cleanly on SIGINT and SIGTERM so that the deferred removal
+	// of the control socket and pid file runs
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	go func() {
+		select {
+		case sig := <-sigs:
+			l.Infof("tunnel %s received %v, stopping", name, sig)
+			shutdown()
+		case <-done:
+		}
+	}()
+	go func() {
+		t.run(stop, connected)
+		close(done)
+	}()
+	if err := <-connected; err != nil {
+		<-done
+		return err
+	}
+	l.Infof("tunnel %s up, control socket %s", name, sockPath)
+
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				cmd, _ := bufio.NewReader(conn).ReadString('\n')
+				switch strings.TrimSpace(cmd) {
+				case "status":
+					json.NewEncoder(conn).Encode(t.status(name, started))
+				case "stop":
+					fmt.Fprintln(conn, "ok")
+					shutdown()
+				}
+			}()
+		}
+	}()
+
+	<-done
+	l.Infof("tunnel %s stopped", name)
+	return nil
+}
+
+func (t *tunnelSession) status(name string, started time.Time) tunnelStatus {
+	t.mu.Lock()
+	st := tunnelStatus{
+		Name:       name,
+		Node:       fmt.Sprintf("%s@%s", t.c.node.user(), t.c.node.addr()),
+		PID:        os.Getpid(),
+		Started:    started,
+		Connected:  t.connected,
+		Since:      t.since,
+		Reconnects: t.reconnects,
+		LastError:  t.lastErr,
+	}
+	t.mu.Unlock()
+	st.Forwards = t.forwarder.stats()
+	return st
+}
+
+// queryTunnel sends cmd to the control socket at sockPath and returns the
+// reply.
+func queryTunnel(sockPath, cmd string) ([]byte, error) {
+	conn, err := net.DialTimeout("unix", sockPath, 2*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := fmt.Fprintln(conn, cmd); err != nil {
+		return nil, err
+	}
+	return io.ReadAll(conn)
+}
+
+func tunnelStatusOf(name string) (*tunnelStatus, error) {
+	sockPath, err := tunnelPath(name, ".sock")
+	if err != nil {
+		return nil, err
+	}
+	b, err := queryTunnel(sockPath, "status")
+	if err != nil {
+		return nil, err
+	}
+	var st tunnelStatus
+	if err := json.Unmarshal(b, &st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+// StartTunnel launches argv (an sshw invocation of "tunnel run") as a
+// detached background process logging to the tunnel's log file, and waits
+// until it reports a connection or exits.
+func StartTunnel(name string, argv []string) error {
+	if st, err := tunnelStatusOf(name); err == nil {
+		return fmt.Errorf("tunnel %s is already running (pid %d)", name, st.PID)
+	}
+
+	logPath, err := tunnelPath(name, ".log")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(logPath), 0700); err != nil {
+		return err
+	}
+	logFile, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	detach(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	deadline := time.After(30 * time.Second)
+	for {
+		select {
+		case <-exited:
+			b, _ := os.ReadFile(logPath)
+			return fmt.Errorf("tunnel %s exited during startup, log %s:\n%s", name, logPath, strings.TrimSpace(string(b)))
+		case <-deadline:
+			return fmt.Errorf("tunnel %s did not come up within 30s, see %s", name, logPath)
+		case <-time.After(200 * time.Millisecond):
+		}
+		if st, err := tunnelStatusOf(name); err == nil && st.Connected {
+			l.Infof("tunnel %s up (pid %d), log %s", name, st.PID, logPath)
+			return nil
+		}
+	}
+}
+
+// StopTunnel asks the background tunnel to shut down, killing its process
+// when the control socket does not answer.
+func StopTunnel(name string) error {
+	sockPath, err := tunnelPath(name, ".sock")
+	if err != nil {
+		return err
+	}
+	pidPath, err := tunnelPath(name, ".pid")
+	if err != nil {
+		return err
+	}
+
+	if _, err := queryTunnel(sockPath, "stop"); err == nil {
+		l.Infof("tunnel %s stopped", name)
+		return nil
+	}
+
+	b, err := os.ReadFile(pidPath)
+	if err != nil {
+		return fmt.Errorf("tunnel %s is not running", name)
+	}
+	defer os.Remove(pidPath)
+	defer os.Remove(sockPath)
+	pid, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil {
+		return fmt.Errorf("bad pid file %s: %w", pidPath, err)
+	}
+	p, err := os.FindProcess(pid)
+	if err == nil {
+		err = p.Kill()
+	}
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("kill tunnel %s (pid %d): %w", name, pid, err)
+	}
+	l.Infof("tunnel %s killed (pid %d)", name, pid)
+	return nil
+}
+
+// TunnelNames lists the tunnels that have state in the tunnel directory.
+func TunnelNames() ([]string, error) {
+	dir, err := stateDir("tunnels")
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if strings.HasSuffix(e.Name(), ".pid") {
+			names = append(names, strings.TrimSuffix(e.Name(), ".pid"))
+		}
+	}
+	return names, nil
+}
+
+// PrintTunnelStatus writes the status of the named background tunnels, or
+// of all of them when names is empty, to w.
+func PrintTunnelStatus(w io.Writer, names []string) error {
+	if len(names) == 0 {
+		var err error
+		if names, err = TunnelNames(); err != nil {
+			return err
+		}
+	}
+	if len(names) == 0 {
+		fmt.Fprintln(w, "no tunnels running")
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TUNNEL\tNODE\tPID\tSTATE\tRECONNECTS\tSENT\tRECEIVED\tLAST ERROR")
+	for _, name := range names {
+		st, err := tunnelStatusOf(name)
+		if err != nil {
+			if removeStaleTunnel(name) {
+				err = errors.New("process gone, removed its stale state files")
+			}
+			fmt.Fprintf(tw, "%s\t\t\tnot running\t\t\t\t%v\n", name, err)
+			continue
+		}
+		state := "down, reconnecting"
+		if st.Connected {
+			state = "up since " + st.Since.Format("2006-01-02 15:04:05")
+		}
+		var sent, received int64
+		for _, f := range st.Forwards {
+			sent += f.BytesOut
+			received += f.BytesIn
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t%d\t%s\t%s\t%s\n", st.Name, st.Node, st.PID, state, st.Reconnects, formatBytes(sent), formatBytes(received), st.LastError)
+		for _, f := range st.Forwards {
+			status := "ok"
+			if f.Error != "" {
+				status = f.Error
+			}
+			fmt.Fprintf(tw, "  %s %s\t%s\t\t%d active, %d total\t\t%s\t%s\t%s\n", f.Kind, f.Name, f.Listen, f.Active, f.Total, formatBytes(f.BytesOut), formatBytes(f.BytesIn), status)
+		}
+	}
+	return tw.Flush()
+}
+
+// removeStaleTunnel removes the control socket and pid file of the tunnel
+// name when the process recorded in the pid file no longer exists, as left
+// behind by a tunnel that was killed. It reports whether it removed them.
+func removeStaleTunnel(name string) bool {
+	pidPath, err := tunnelPath(name, ".pid")
+	if err != nil {
+		return false
+	}
+	b, err := os.ReadFile(pidPath)
+	if err != nil {
+		return false
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(b))); err == nil && processAlive(pid) {
+		return false
+	}
+	if sockPath, err := tunnelPath(name, ".sock"); err == nil {
+		os.Remove(sockPath)
+	}
+	return os.Remove(pidPath) == nil
+}
@@ -0,0 +1,246 @@
+package sshw
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// x11Timeout is how long, in seconds, an untrusted cookie stays valid for
+// new connections, matching OpenSSH's ForwardX11Timeout default.
+const x11Timeout = 1200
+
+// x11Forwarding proxies the "x11" channels of a session to the local display,
+// replacing the fake cookie handed to the server with the real one.
+type x11Forwarding struct {
+	display    string
+	proto      string
+	fakeCookie []byte
+	realCookie []byte
+	logf       func(format string, args ...interface{})
+	cleanup    func()
+}
+
+// forwardX11 requests X11 forwarding for session and starts accepting the
+// server's "x11" channels. In untrusted mode the real cookie is a freshly
+// generated untrusted one, limiting what remote clients may do to the display.
+func forwardX11(client *ssh.Client, session *ssh.Session, node *Node, logf func(format string, args ...interface{})) (*x11Forwarding, error) {
+	display := os.Getenv("DISPLAY")
+	if display == "" {
+		return nil, errors.New("X11 forwarding requested but DISPLAY is not set")
+	}
+
+	x := &x11Forwarding{
+		display: display,
+		proto:   "MIT-MAGIC-COOKIE-1",
+		logf:    logf,
+		cleanup: func() {},
+	}
+	if err := x.loadCookie(node.ForwardX11Trusted); err != nil {
+		if !node.ForwardX11Trusted {
+			return nil, fmt.Errorf("untrusted X11 forwarding setup failed: %w", err)
+		}
+		logf("no xauth data for %s, X11 connections are forwarded without a cookie: %v", display, err)
+	}
+
+	n := len(x.realCookie)
+	if n == 0 {
+		n = 16
+	}
+	x.fakeCookie = make([]byte, n)
+	if _, err := rand.Read(x.fakeCookie); err != nil {
+		x.cleanup()
+		return nil, err
+	}
+
+	chans := client.HandleChannelOpen("x11")
+	if chans == nil {
+		x.cleanup()
+		return nil, errors.New("x11 channels are already handled on this connection")
+	}
+	go func() {
+		for ch := range chans {
+			go x.handle(ch)
+		}
+	}()
+
+	req := struct {
+		SingleConnection bool
+		AuthProtocol     string
+		AuthCookie       string
+		ScreenNumber     uint32
+	}{
+		AuthProtocol: x.proto,
+		AuthCookie:   hex.EncodeToString(x.fakeCookie),
+		ScreenNumber: displayScreen(display),
+	}
+	ok, err := session.SendRequest("x11-req", true, ssh.Marshal(&req))
+	if err == nil && !ok {
+		err = errors.New("server refused X11 forwarding (is X11Forwarding enabled?)")
+	}
+	if err != nil {
+		x.cleanup()
+		return nil, err
+	}
+	return x, nil
+}
+
+// Close removes the temporary xauth data of an untrusted forwarding.
+func (x *x11Forwarding) Close() {
+	x.cleanup()
+}
+
+// loadCookie reads the display's cookie with xauth. For untrusted
+// forwarding a new untrusted cookie is generated in a throwaway file.
+func (x *x11Forwarding) loadCookie(trusted bool) error {
+	var args []string
+	if !trusted {
+		dir, err := os.MkdirTemp("", "sshw-xauth-")
+		if err != nil {
+			return err
+		}
+		x.cleanup = func() { os.RemoveAll(dir) }
+		file := filepath.Join(dir, "xauthfile")
+		out, err := exec.Command("xauth", "-q", "-f", file, "generate", x.display, x.proto, "untrusted", "timeout", strconv.Itoa(x11Timeout)).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("xauth generate: %v: %s", err, bytes.TrimSpace(out))
+		}
+		args = []string{"-f", file}
+	}
+
+	out, err := exec.Command("xauth", append(args, "list", x.display)...).Output()
+	if err != nil {
+		return fmt.Errorf("xauth list: %w", err)
+	}
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		cookie, err := hex.DecodeString(fields[2])
+		if err != nil {
+			continue
+		}
+		x.proto = fields[1]
+		x.realCookie = cookie
+		return nil
+	}
+	return fmt.Errorf("xauth has no cookie for %s", x.display)
+}
+
+func (x *x11Forwarding) handle(ch ssh.NewChannel) {
+	local, err := dialDisplay(x.display)
+	if err != nil {
+		x.logf("X11 connection rejected: %v", err)
+		ch.Reject(ssh.ConnectionFailed, err.Error())
+		return
+	}
+	channel, reqs, err := ch.Accept()
+	if err != nil {
+		local.Close()
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+
+	if err := x.spoofSetup(channel, local); err != nil {
+		x.logf("X11 connection rejected: %v", err)
+		channel.Close()
+		local.Close()
+		return
+	}
+	pipe(channel, local, new(atomic.Int64), new(atomic.Int64))
+}
+
+// spoofSetup reads the X11 connection setup sent by the remote client,
+// checks that it carries the fake cookie and writes it to the display with
+// the real cookie instead.
+func (x *x11Forwarding) spoofSetup(remote io.Reader, local io.Writer) error {
+	hdr := make([]byte, 12)
+	if _, err := io.ReadFull(remote, hdr); err != nil {
+		return err
+	}
+	var order binary.ByteOrder
+	switch hdr[0] {
+	case 'B':
+		order = binary.BigEndian
+	case 'l':
+		order = binary.LittleEndian
+	default:
+		return fmt.Errorf("bad X11 byte order %#x", hdr[0])
+	}
+	nameLen := int(order.Uint16(hdr[6:]))
+	dataLen := int(order.Uint16(hdr[8:]))
+	body := make([]byte, pad4(nameLen)+pad4(dataLen))
+	if _, err := io.ReadFull(remote, body); err != nil {
+		return err
+	}
+	name := body[:nameLen]
+	data := body[pad4(nameLen) : pad4(nameLen)+dataLen]
+	if string(name) != x.proto || subtle.ConstantTimeCompare(data, x.fakeCookie) != 1 {
+		return errors.New("wrong X11 authentication data")
+	}
+	if x.realCookie != nil {
+		copy(data, x.realCookie)
+	}
+
+	if _, err := local.Write(hdr); err != nil {
+		return err
+	}
+	_, err := local.Write(body)
+	return err
+}
+
+func pad4(n int) int {
+	return (n + 3) &^ 3
+}
+
+// dialDisplay connects to the X server named by display: a unix socket for
+// ":N" and "unix:N", a socket path for launchd style displays, TCP port
+// 6000+N otherwise.
+func dialDisplay(display string) (net.Conn, error) {
+	if strings.HasPrefix(display, "/") {
+		return net.Dial("unix", display)
+	}
+	i := strings.LastIndex(display, ":")
+	if i < 0 {
+		return nil, fmt.Errorf("bad DISPLAY %q", display)
+	}
+	host := display[:i]
+	num := display[i+1:]
+	if j := strings.Index(num, "."); j >= 0 {
+		num = num[:j]
+	}
+	n, err := strconv.Atoi(num)
+	if err != nil {
+		return nil, fmt.Errorf("bad DISPLAY %q", display)
+	}
+	if host == "" || host == "unix" {
+		return net.Dial("unix", fmt.Sprintf("/tmp/.X11-unix/X%d", n))
+	}
+	return net.Dial("tcp", net.JoinHostPort(host, strconv.Itoa(6000+n)))
+}
+
+// displayScreen returns the screen number of display, ":0.1" -> 1.
+func displayScreen(display string) uint32 {
+	i := strings.LastIndex(display, ":")
+	if j := strings.LastIndex(display, "."); i >= 0 && j > i {
+		if n, err := strconv.Atoi(display[j+1:]); err == nil {
+			return uint32(n)
+		}
+	}
+	return 0
+}
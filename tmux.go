@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hellojukay/sshw/sshwpkg"
+	"github.com/manifoldco/promptui"
+)
+
+const tmuxUsage = `usage:
+  sshw tmux [-panes] [-sync] <group|alias|tag>...   open every node in a tmux window, or in panes of one window`
+
+// runTmux handles "sshw tmux ..." and returns the process exit code.
+func runTmux(args []string) int {
+	fs := flag.NewFlagSet("tmux", flag.ContinueOnError)
+	panes := fs.Bool("panes", false, "open the nodes in tiled panes of one window")
+	sync := fs.Bool("sync", false, "open the nodes in panes with synchronize-panes on")
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, tmuxUsage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+
+	var nodes []*sshw.Node
+	seen := make(map[*sshw.Node]bool)
+	for _, target := range fs.Args() {
+		found := sshw.FindTargets(sshw.GetConfig(), target)
+		if len(found) == 0 {
+			log.Error("no nodes found for:", target)
+			return 1
+		}
+		for _, node := range found {
+			if !seen[node] {
+				seen[node] = true
+				nodes = append(nodes, node)
+			}
+		}
+	}
+	if err := openTmux(nodes, *panes || *sync, *sync); err != nil {
+		log.Error(err)
+		return 1
+	}
+	return 0
+}
+
+// openTmux opens a tmux window or pane per node, each running sshw with the
+// same inventory and flags logged into the node.
+func openTmux(nodes []*sshw.Node, panes, sync bool) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cfg := *F
+	if !strings.HasPrefix(cfg, "~") {
+		// the windows do not start in the current directory
+		if cfg, err = filepath.Abs(cfg); err != nil {
+			return err
+		}
+	}
+	base := []string{exe, "-f", cfg}
+	if *S {
+		base = []string{exe, "-s"}
+	}
+	if *X {
+		base = append(base, "-X")
+	}
+	if *Y {
+		base = append(base, "-Y")
+	}
+
+	windows := make([]sshw.TmuxWindow, 0, len(nodes))
+	for _, node := range nodes {
+		addr := sshw.NodeAddress(sshw.GetConfig(), node)
+		if addr == "" {
+			return fmt.Errorf("%s cannot be addressed unambiguously, give it a unique alias", node.Name)
+		}
+		argv := append(append([]string(nil), base...), addr)
+		for i := range argv {
+			argv[i] = sshw.ShellQuote(argv[i])
+		}
+		name := node.Name
+		if name == "" {
+			name = addr
+		}
+		windows = append(windows, sshw.TmuxWindow{Name: name, Command: strings.Join(argv, " ")})
+	}
+	return sshw.OpenTmux(windows, panes, sync)
+}
+
+// chooseTmuxLayout asks how the selected nodes are laid out in tmux.
+func chooseTmuxLayout() (panes, sync, ok bool) {
+	prompt := promptui.Select{
+		Label:        "open hosts in",
+		Items:        []string{"windows", "panes", "panes with synchronize-panes"},
+		HideSelected: true,
+		Templates: &promptui.SelectTemplates{
+			Label:    "✨ {{ . | green}}",
+			Active:   "➤ {{ . | cyan }}",
+			Inactive: "  {{ . | faint }}",
+		},
+	}
+	index, _, err := prompt.Run()
+	if err != nil {
+		return false, false, false
+	}
+	return index > 0, index == 2, true
+}
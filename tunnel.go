@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hellojukay/sshw/sshwpkg"
+)
+
+const tunnelUsage = `usage:
+  sshw tunnel up <alias> [forward-name...]   start the node's forwards in the background
+  sshw tunnel down <alias>...                stop background tunnels
+  sshw tunnel status [alias...]              show background tunnels`
+
+// runTunnel handles "sshw tunnel ..." and returns the process exit code.
+func runTunnel(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, tunnelUsage)
+		return 2
+	}
+
+	switch args[0] {
+	case "up", "run":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, tunnelUsage)
+			return 2
+		}
+		node := sshw.FindNode(sshw.GetConfig(), args[1])
+		if node == nil {
+			log.Error("alias not found:", args[1])
+			return 1
+		}
+		var err error
+		if args[0] == "run" {
+			// body of the background process started by "up"
+			err = sshw.RunTunnel(args[1], node, args[2:])
+		} else {
+			err = startTunnel(args[1], args[2:])
+		}
+		if err != nil {
+			log.Error(err)
+			return 1
+		}
+	case "down":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, tunnelUsage)
+			return 2
+		}
+		code := 0
+		for _, name := range args[1:] {
+			if err := sshw.StopTunnel(name); err != nil {
+				log.Error(err)
+				code = 1
+			}
+		}
+		return code
+	case "status":
+		if err := sshw.PrintTunnelStatus(os.Stdout, args[1:]); err != nil {
+			log.Error(err)
+			return 1
+		}
+	default:
+		fmt.Fprintln(os.Stderr, tunnelUsage)
+		return 2
+	}
+	return 0
+}
+
+// startTunnel re-executes sshw with the same inventory as "tunnel run" in
+// a detached background process.
+func startTunnel(alias string, forwards []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	argv := []string{exe, "-f", *F}
+	if *S {
+		argv = []string{exe, "-s"}
+	}
+	argv = append(argv, "tunnel", "run", alias)
+	argv = append(argv, forwards...)
+	return sshw.StartTunnel(alias, argv)
+}